@@ -4,11 +4,35 @@ import "github.com/veandco/go-sdl2/sdl"
 
 // ProcessInput polls SDL events and returns false if a quit event is received.
 func ProcessInput() bool {
+	return ProcessEvents(nil)
+}
+
+// ProcessEvents is ProcessInput passing every other event to handle, unless
+// handle is nil.
+func ProcessEvents(handle func(event sdl.Event)) bool {
 	for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
 		switch event.(type) {
 		case *sdl.QuitEvent:
 			return false
+		default:
+			if handle != nil {
+				handle(event)
+			}
 		}
 	}
 	return true
 }
+
+// Direction reads a pair of keys held on the keyboard: -1 for up, +1 for
+// down, 0 for neither or both.
+func Direction(up, down sdl.Scancode) int {
+	keys := sdl.GetKeyboardState()
+	direction := 0
+	if keys[up] != 0 {
+		direction--
+	}
+	if keys[down] != 0 {
+		direction++
+	}
+	return direction
+}
@@ -0,0 +1,136 @@
+package game
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"pong-multiplayer/network"
+
+	"github.com/veandco/go-sdl2/sdl"
+	"github.com/veandco/go-sdl2/ttf"
+)
+
+const (
+	// chatLines is how many chat lines the overlay shows.
+	chatLines = 6
+	// chatFade is how long a chat line stays on screen while the input box
+	// is closed.
+	chatFade = 10 * time.Second
+	// chatLineHeight is the vertical distance between chat lines.
+	chatLineHeight = 20
+)
+
+type chatLine struct {
+	text string
+	at   time.Time
+}
+
+// Chat is the in-game chat: the lines received so far and the input box,
+// which Enter opens and sends. Lines are added from the network goroutines.
+type Chat struct {
+	// Send is called with the text typed into the input box. Without it
+	// the input box never opens.
+	Send func(text string) error
+
+	mu     sync.Mutex
+	lines  []chatLine
+	typing bool
+	input  string
+}
+
+// Add appends a received chat line, dropping the oldest beyond chatLines.
+func (c *Chat) Add(from, text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lines = append(c.lines, chatLine{text: fmt.Sprintf("%s: %s", from, text), at: time.Now()})
+	if len(c.lines) > chatLines {
+		c.lines = c.lines[len(c.lines)-chatLines:]
+	}
+}
+
+// Typing reports whether the input box is open, in which case the
+// keyboard belongs to it rather than the paddle.
+func (c *Chat) Typing() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.typing
+}
+
+// HandleEvent feeds a window event to the chat. Enter opens the input box
+// and sends its text, Escape closes it without sending.
+func (c *Chat) HandleEvent(event sdl.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch e := event.(type) {
+	case *sdl.KeyboardEvent:
+		if e.Type != sdl.KEYDOWN || c.Send == nil {
+			return
+		}
+		switch e.Keysym.Sym {
+		case sdl.K_RETURN, sdl.K_KP_ENTER:
+			if !c.typing {
+				c.typing = true
+				sdl.StartTextInput()
+				return
+			}
+			if c.input != "" {
+				text := c.input
+				// Send may block on the network; don't hold the lock.
+				go func() {
+					if err := c.Send(text); err != nil {
+						fmt.Println("Error sending chat message:", err)
+					}
+				}()
+			}
+			c.closeLocked()
+		case sdl.K_ESCAPE:
+			if c.typing {
+				c.closeLocked()
+			}
+		case sdl.K_BACKSPACE:
+			if c.typing && c.input != "" {
+				_, size := utf8.DecodeLastRuneInString(c.input)
+				c.input = c.input[:len(c.input)-size]
+			}
+		}
+	case *sdl.TextInputEvent:
+		if c.typing && utf8.RuneCountInString(c.input) < network.MaxChatLength {
+			c.input += e.GetText()
+		}
+	}
+}
+
+func (c *Chat) closeLocked() {
+	c.typing = false
+	c.input = ""
+	sdl.StopTextInput()
+}
+
+// Render draws the recent chat lines in the bottom-left corner and, while
+// typing, the input box below them.
+func (c *Chat) Render(renderer *sdl.Renderer, font *ttf.Font) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	y := int32(windowHeight - 10 - chatLineHeight)
+	if c.typing {
+		renderer.SetDrawBlendMode(sdl.BLENDMODE_BLEND)
+		renderer.SetDrawColor(0, 0, 0, 160)
+		renderer.FillRect(&sdl.Rect{X: 5, Y: y - 2, W: windowWidth - 10, H: chatLineHeight + 4})
+		if err := renderText(renderer, font, "> "+c.input+"_", 10, y); err != nil {
+			fmt.Println("Error rendering chat input:", err)
+		}
+	}
+	y -= chatLineHeight
+	for i := len(c.lines) - 1; i >= 0; i-- {
+		line := c.lines[i]
+		if !c.typing && time.Since(line.at) > chatFade {
+			break
+		}
+		if err := renderText(renderer, font, line.text, 10, y); err != nil {
+			fmt.Println("Error rendering chat line:", err)
+		}
+		y -= chatLineHeight
+	}
+}
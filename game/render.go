@@ -0,0 +1,148 @@
+package game
+
+import (
+	"fmt"
+	"time"
+
+	"pong-multiplayer/engine"
+	"pong-multiplayer/network"
+	"pong-multiplayer/sim"
+
+	"github.com/veandco/go-sdl2/gfx"
+	"github.com/veandco/go-sdl2/sdl"
+	"github.com/veandco/go-sdl2/ttf"
+)
+
+// paddleKeys are the up and down keys of each paddle: W/S for the left one
+// and the arrow keys for the right one.
+var paddleKeys = [2][2]sdl.Scancode{
+	{sdl.SCANCODE_W, sdl.SCANCODE_S},
+	{sdl.SCANCODE_UP, sdl.SCANCODE_DOWN},
+}
+
+// InputDirection reads the keys of the local paddle: -1 for up, +1 for
+// down, 0 for none. While the chat input box is open, the keyboard is
+// typing instead and no paddle moves.
+func (g *Game) InputDirection() int {
+	if g.LocalSlot < 0 || g.LocalSlot >= len(paddleKeys) || g.Chat.Typing() {
+		return 0
+	}
+	keys := paddleKeys[g.LocalSlot]
+	return engine.Direction(keys[0], keys[1])
+}
+
+// updateTitle shows the score in the window title.
+func (g *Game) updateTitle() {
+	left, right := g.Score()
+	g.Engine.Window.SetTitle(fmt.Sprintf("Multiplayer Pong - Left: %d | Right: %d", left, right))
+}
+
+// renderBall draws the ball as a filled circle.
+func renderBall(renderer *sdl.Renderer, b *sim.Ball) {
+	// Calculate the center of the ball and its radius directly as int32 values.
+	centerX := int32(b.X + float32(b.Size)/2)
+	centerY := int32(b.Y + float32(b.Size)/2)
+	radius := b.Size / 2
+
+	// Draw a filled circle for the ball.
+	if ok := gfx.FilledCircleRGBA(renderer, centerX, centerY, radius, 255, 255, 255, 255); !ok {
+		// If drawing the circle fails, fall back to drawing a rectangle.
+		renderer.SetDrawColor(255, 255, 255, 255)
+		rect := sdl.Rect{X: int32(b.X), Y: int32(b.Y), W: b.Size, H: b.Size}
+		renderer.FillRect(&rect)
+	}
+}
+
+// renderPaddle draws the paddle with rounded borders.
+func renderPaddle(renderer *sdl.Renderer, p *sim.Player) {
+	// Convert paddle position and dimensions to int32.
+	x1 := int32(p.X)
+	y1 := int32(p.Y)
+	x2 := x1 + p.Width
+	y2 := y1 + p.Height
+	// Use a smaller radius so that the interior gets filled.
+	radius := int32(2)
+
+	// Draw a filled rounded rectangle.
+	if ok := gfx.RoundedBoxRGBA(renderer, x1, y1, x2, y2, radius, 255, 255, 255, 255); !ok {
+		// If drawing the rounded rectangle fails, fall back to a standard rectangle.
+		renderer.SetDrawColor(255, 255, 255, 255)
+		rect := sdl.Rect{X: x1, Y: y1, W: p.Width, H: p.Height}
+		renderer.FillRect(&rect)
+	}
+}
+
+// Render draws the ball and the paddles where they are now. The admin
+// console may reset the match at any time, so they are copied first.
+func (g *Game) Render() {
+	ball, p1, p2 := g.Positions()
+	renderBall(g.Engine.Renderer, &ball)
+	renderPaddle(g.Engine.Renderer, &p1)
+	renderPaddle(g.Engine.Renderer, &p2)
+}
+
+func (g *Game) Run() {
+	var lastTime uint64 = sdl.GetTicks64()
+	for g.Engine.Running && !g.stopped.Load() {
+		// Process input; the host's local controls move its paddle in the
+		// following steps.
+		g.Engine.Running = engine.ProcessInput()
+		g.SetLocalDirection(g.InputDirection())
+
+		currentTime := sdl.GetTicks64()
+		elapsed := time.Duration(currentTime-lastTime) * time.Millisecond
+		lastTime = currentTime
+
+		g.Advance(elapsed)
+		g.updateTitle()
+		g.Engine.Clear()
+		g.Render()
+		g.Engine.Present()
+		sdl.Delay(16)
+	}
+}
+
+// RunOverlay is Run with a HUD showing frame rate, spectators and the ping
+// and loss reported by stats, and with the chat.
+func (g *Game) RunOverlay(font *ttf.Font, stats func() network.Stats) {
+	lastRender := time.Now()
+	for g.Engine.Running && !g.stopped.Load() {
+		g.Engine.Running = engine.ProcessEvents(g.Chat.HandleEvent)
+		g.SetLocalDirection(g.InputDirection())
+
+		dt := time.Since(lastRender)
+		lastRender = time.Now()
+		g.Advance(dt)
+		g.updateTitle()
+		g.Engine.Clear()
+		g.Render()
+		st := stats()
+		infoText := fmt.Sprintf("FPS: %.0f  Ping: %d ms  Loss: %.0f%%  Spectators: %d",
+			1.0/dt.Seconds(), st.RTT.Milliseconds(), 100*st.Loss(), g.Spectators.Load())
+		if err := renderText(g.Engine.Renderer, font, infoText, 10, 10); err != nil {
+			fmt.Println("Error rendering info text:", err)
+		}
+		g.Chat.Render(g.Engine.Renderer, font)
+		g.Engine.Present()
+		sdl.Delay(16)
+	}
+}
+
+// renderText renders the given text using SDL_ttf and draws it on the renderer.
+func renderText(renderer *sdl.Renderer, font *ttf.Font, text string, x, y int32) error {
+	color := sdl.Color{R: 255, G: 255, B: 255, A: 255}
+	surface, err := font.RenderUTF8Solid(text, color)
+	if err != nil {
+		return fmt.Errorf("failed to render text surface: %w", err)
+	}
+	defer surface.Free()
+
+	texture, err := renderer.CreateTextureFromSurface(surface)
+	if err != nil {
+		return fmt.Errorf("failed to create text texture: %w", err)
+	}
+	defer texture.Destroy()
+
+	rect := sdl.Rect{X: x, Y: y, W: surface.W, H: surface.H}
+	return renderer.Copy(texture, nil, &rect)
+}
@@ -0,0 +1,14 @@
+//go:build headless
+
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// runWindow fails: a headless build has no window, so it can only run the
+// server, lobby and rendezvous modes.
+func runWindow(ctx context.Context, mode, address, invite string, open bool, rendezvous, websocket, admin string, encrypt bool) {
+	log.Fatalf("This build has no display; run with -mode=server, lobby or rendezvous, or build without -tags headless")
+}
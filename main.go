@@ -1,377 +1,312 @@
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"math/rand"
-	"strconv"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
 	"time"
-	"unsafe"
 
-	"pong-multiplayer/engine"
-	"pong-multiplayer/game"
 	"pong-multiplayer/network"
-	"pong-multiplayer/shared"
-
-	"github.com/veandco/go-sdl2/sdl"
-	"github.com/veandco/go-sdl2/ttf"
-)
-
-const (
-	buttonWidth  = 200
-	buttonHeight = 50
+	"pong-multiplayer/sim"
 )
 
-// MenuState defines which part of the menu is active.
-type MenuState int
+// conditions simulate a bad network on every game socket, as set by the
+// -latency, -jitter, -loss, -duplicate and -reorder flags.
+var conditions network.Conditions
 
-const (
-	MenuMain MenuState = iota
-	MenuJoinInput
-)
-
-const defaultRenderDelay = int64(100 * 1e6) // 100ms in ns
+// allowedOrigins lists the web pages that may connect to the WebSocket
+// endpoint, as set by the -origins flag.
+var allowedOrigins []string
 
 func main() {
-	eng, err := engine.NewEngine("Multiplayer Pong", 800, 600)
-	if err != nil {
-		log.Fatalf("Engine initialization failed: %v", err)
+	mode := flag.String("mode", "", "run mode: server, lobby, rendezvous, host or client (default: interactive menu)")
+	address := flag.String("address", ":9000", "address to listen on (server/lobby/host) or connect to (client)")
+	invite := flag.String("invite", "", "invite code to require (server/host) or present (client)")
+	open := flag.Bool("open", false, "host without an invite code (server/host)")
+	encrypt := flag.Bool("encrypt", false, "encrypt traffic as well as authenticating it (server/lobby/host)")
+	rendezvous := flag.String("rendezvous", "", "rendezvous server to register with (server/host) or find the host through (client)")
+	websocket := flag.String("websocket", "", "address to also accept WebSocket clients, such as browsers, on (server/host)")
+	flag.Func("origins", "comma-separated origins of web pages allowed to connect over WebSocket, or * for any (server/host)", func(s string) error {
+		allowedOrigins = append(allowedOrigins, strings.Split(s, ",")...)
+		return nil
+	})
+	admin := flag.String("admin", "", "loopback address to serve the admin console on, e.g. 127.0.0.1:9100 (server/host)")
+	flag.DurationVar(&conditions.Latency, "latency", 0, "simulated one-way latency added to every packet sent and received")
+	flag.DurationVar(&conditions.Jitter, "jitter", 0, "simulated random delay added on top of -latency")
+	flag.Float64Var(&conditions.Loss, "loss", 0, "simulated probability of losing a packet")
+	flag.Float64Var(&conditions.Duplicate, "duplicate", 0, "simulated probability of delivering a packet twice")
+	flag.Float64Var(&conditions.Reorder, "reorder", 0, "simulated probability of delivering a packet out of order")
+	flag.Int64Var(&conditions.Seed, "seed", 0, "seed for the simulated network conditions, to repeat a run (default: random)")
+	flag.Parse()
+
+	// Interrupting a headless server or rendezvous server shuts it down
+	// cleanly.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var selectedMode string // "host", "join" or empty for the menu
+	switch *mode {
+	case "server":
+		// The dedicated server never touches SDL, so it runs without a
+		// display, and builds without SDL with -tags headless.
+		runServer(ctx, *address, hostInviteCode(*invite, *open), *rendezvous, *websocket, *admin, *encrypt)
+		return
+	case "lobby":
+		runLobby(ctx, *address, *encrypt)
+		return
+	case "rendezvous":
+		rv := network.NewRendezvous(*address)
+		go logRejects(rv.RejectStats)
+		if err := rv.Start(ctx); err != nil {
+			log.Fatalf("Rendezvous error: %v", err)
+		}
+		return
+	case "host":
+		selectedMode = "host"
+	case "client":
+		if *invite == "" {
+			log.Fatalf("Client mode requires -invite")
+		}
+		selectedMode = "join"
+	case "":
+	default:
+		log.Fatalf("Unknown mode %q (expected server, lobby, rendezvous, host or client)", *mode)
 	}
-	defer eng.Shutdown()
 
-	// Initialize TTF
-	if err := ttf.Init(); err != nil {
-		log.Fatalf("TTF initialization failed: %v", err)
-	}
-	defer ttf.Quit()
+	runWindow(ctx, selectedMode, *address, *invite, *open, *rendezvous, *websocket, *admin, *encrypt)
+}
 
-	// Open a font (ensure the TTF file exists in your working directory)
-	font, err := ttf.OpenFont("arial.ttf", 16)
-	if err != nil {
-		log.Fatalf("Failed to open font: %v", err)
+// hostInviteCode returns the invite code a host requires: the given one,
+// none for an open game, or else a generated one.
+func hostInviteCode(invite string, open bool) string {
+	if open {
+		return ""
 	}
-	defer font.Close()
-
-	var state MenuState = MenuMain
-	var selectedMode string   // "host" or "join"
-	var joinInviteCode string // entered by the joining player
-
-	// Define button rectangles.
-	hostBtn := sdl.Rect{X: 300, Y: 200, W: buttonWidth, H: buttonHeight}
-	joinBtn := sdl.Rect{X: 300, Y: 300, W: buttonWidth, H: buttonHeight}
-
-	// When hosting, generate an invite code.
-	inviteCode := generateInviteCode()
-
-	// Declare a buffer for received states.
-	var stateBuffer []shared.State
-
-	// Main menu loop.
-	for {
-		for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
-			switch ev := event.(type) {
-			case *sdl.QuitEvent:
-				return
-			case *sdl.MouseButtonEvent:
-				if ev.Type == sdl.MOUSEBUTTONDOWN {
-					x, y := ev.X, ev.Y
-					if state == MenuMain {
-						if pointInRect(int32(x), int32(y), hostBtn) {
-							selectedMode = "host"
-						} else if pointInRect(int32(x), int32(y), joinBtn) {
-							selectedMode = "join"
-							state = MenuJoinInput
-							joinInviteCode = ""
-							sdl.StartTextInput()
-						}
-					}
-				}
-			case *sdl.TextInputEvent:
-				if state == MenuJoinInput {
-					// Convert the fixed C array to a Go slice and trim at the first null byte.
-					textArr := (*[32]byte)(unsafe.Pointer(&ev.Text))
-					n := 0
-					for ; n < len(textArr) && textArr[n] != 0; n++ {
-					}
-					joinInviteCode += string(textArr[:n])
-				}
-			case *sdl.KeyboardEvent:
-				if state == MenuJoinInput && ev.Type == sdl.KEYDOWN {
-					if ev.Keysym.Sym == sdl.K_BACKSPACE && len(joinInviteCode) > 0 {
-						joinInviteCode = joinInviteCode[:len(joinInviteCode)-1]
-					} else if ev.Keysym.Sym == sdl.K_RETURN {
-						// Finished entering invite code.
-						state = MenuMain
-						sdl.StopTextInput()
-						selectedMode = "join"
-					}
-				}
-			}
-		}
-
-		// Render the menu.
-		eng.Renderer.SetDrawColor(50, 50, 50, 255)
-		eng.Renderer.Clear()
-
-		if state == MenuMain {
-			drawButton(eng.Renderer, hostBtn, "Host ("+inviteCode+")")
-			drawButton(eng.Renderer, joinBtn, "Join")
-		} else if state == MenuJoinInput {
-			// Draw input area.
-			inputRect := sdl.Rect{X: 300, Y: 400, W: buttonWidth, H: buttonHeight}
-			eng.Renderer.SetDrawColor(200, 200, 200, 255)
-			eng.Renderer.FillRect(&inputRect)
-			eng.Renderer.SetDrawColor(0, 0, 0, 255)
-			eng.Renderer.DrawRect(&inputRect)
+	if invite == "" {
+		return generateInviteCode()
+	}
+	return invite
+}
 
-			// Render the typed text.
-			if joinInviteCode != "" {
-				surface, err := font.RenderUTF8Blended(joinInviteCode, sdl.Color{R: 0, G: 0, B: 0, A: 255})
-				if err == nil {
-					texture, err := eng.Renderer.CreateTextureFromSurface(surface)
-					if err == nil {
-						var tw, th int32
-						_, _, tw, th, err = texture.Query()
-						if err == nil {
-							dst := sdl.Rect{X: inputRect.X + 5, Y: inputRect.Y + (inputRect.H-th)/2, W: tw, H: th}
-							eng.Renderer.Copy(texture, nil, &dst)
-						}
-						texture.Destroy()
-					}
-					surface.Free()
-				}
-			}
-		}
+// announceName returns the name this machine's games are announced under
+// on the LAN.
+func announceName() string {
+	if name, err := os.Hostname(); err == nil && name != "" {
+		return name
+	}
+	return "Pong"
+}
 
-		eng.Renderer.Present()
-		sdl.Delay(16)
-		// Exit menu loop if a mode is selected.
-		if selectedMode != "" && state == MenuMain {
-			break
+// runServer runs a dedicated, headless server: the simulation is driven
+// entirely by the two remote players and no window or font is opened.
+func runServer(ctx context.Context, address, inviteCode, rendezvous, websocket, admin string, encrypt bool) {
+	server := network.NewServer(address, inviteCode)
+	server.Conditions = conditions
+	server.Encrypt = encrypt
+	server.Rendezvous = rendezvous
+	server.AnnounceName = announceName()
+	server.OnChat = logChat
+	// Callbacks must be in place before the server starts.
+	m := sim.NewMatch()
+	server.InputUpdate = inputUpdateHandler(server, m)
+	pauseOnDisconnect(server, m)
+	go func() {
+		if err := server.Start(ctx); err != nil {
+			log.Fatalf("Server error: %v", err)
 		}
+	}()
+	log.Printf("Dedicated server on %s. Invite code: %s", address, inviteCode)
+	serveWebSocket(server, websocket)
+	go logRejects(server.RejectStats)
+	serveAdmin(ctx, server, m, admin)
+
+	// Both paddles belong to remote players here.
+	if !waitForClients(server, 2) {
+		return
 	}
+	log.Printf("Both players connected, starting match")
+	go broadcastState(server, m, func() bool { return true })
+	m.RunHeadless(server.Done())
+}
 
-	// In host mode, start the server in a goroutine and log the invite code.
-	if selectedMode == "host" {
-		// Create the server with the expected invite code.
-		server := network.NewServer("localhost:9000", inviteCode)
+// runLobby runs a headless lobby server hosting one match per invite code.
+// A room opens when the first player presents a new code and closes once
+// everyone has left.
+func runLobby(ctx context.Context, address string, encrypt bool) {
+	lobby := network.NewLobby(address)
+	lobby.Conditions = conditions
+	lobby.Encrypt = encrypt
+	lobby.OnRoomOpened = func(room *network.Server) {
+		// Callbacks must be in place before the room's first handshake.
+		m := sim.NewMatch()
+		room.InputUpdate = inputUpdateHandler(room, m)
+		room.OnChat = logChat
+		pauseOnDisconnect(room, m)
 		go func() {
-			if err := server.Start(); err != nil {
-				log.Fatalf("Server error: %v", err)
+			if !waitForClients(room, 2) {
+				return
 			}
+			log.Printf("Room %s: both players connected, starting match", room.ExpectedInviteCode)
+			go broadcastState(room, m, func() bool { return !isDone(room.Done()) })
+			m.RunHeadless(room.Done())
 		}()
-		log.Printf("Hosting game. Invite code: %s", inviteCode)
+	}
+	log.Printf("Lobby server on %s", address)
+	go logRejects(lobby.RejectStats)
+	if err := lobby.Start(ctx); err != nil {
+		log.Fatalf("Lobby error: %v", err)
+	}
+}
 
-		// Immediately connect as client using the generated invite code.
-		client := network.NewClient("localhost:9000")
-		if err := client.Connect(inviteCode); err != nil {
-			log.Fatalf("Client connection failed: %v", err)
-		}
+// serveWebSocket lets clients such as browsers join server through a
+// WebSocket endpoint at address, unless address is empty.
+func serveWebSocket(server *network.Server, address string) {
+	if address == "" {
+		return
+	}
+	conn, err := network.WebSocketTransport{AllowedOrigins: allowedOrigins}.Listen(address)
+	if err != nil {
+		log.Fatalf("WebSocket error: %v", err)
+	}
+	log.Printf("Accepting WebSocket clients at ws://%s%s", address, network.DefaultWebSocketPath)
+	go server.Serve(conn)
+}
 
-		// Wait until at least one remote client has connected.
-		// Because the host's own connection is in the Clients map,
-		// we assume a remote player has joined when Clients has >= 2.
-		for {
-			server.Lock.Lock()
-			count := len(server.Clients)
-			server.Lock.Unlock()
-			if count >= 2 {
-				break
-			}
-			sdl.Delay(16)
+// serveAdmin serves the admin console for server and its match m on
+// address, unless address is empty. Ending the match closes the server.
+func serveAdmin(ctx context.Context, server *network.Server, m *sim.Match, address string) {
+	if address == "" {
+		return
+	}
+	console := network.NewAdminConsole(address, server)
+	console.OnPause = func() { m.SetPaused(true) }
+	console.OnResume = func() { m.SetPaused(false) }
+	console.OnReset = m.Reset
+	console.OnEnd = func() {
+		log.Printf("Match ended by the admin")
+		server.Close()
+	}
+	go func() {
+		if err := console.Start(ctx); err != nil {
+			log.Fatalf("Admin console error: %v", err)
 		}
+	}()
+	go func() {
+		<-server.Done()
+		console.Close()
+	}()
+}
 
-		// Create the game instance.
-		g := game.NewGame(eng)
-
-		// Initialize a sequence counter.
-		var seq uint32 = 0
-
-		// Set a callback on the server so that when an "input_update" message is received,
-		// the RemoteInput field is updated (expecting msg.Data to be an integer as a string).
-		server.InputUpdate = func(msg network.Message) {
-			direction, err := strconv.Atoi(string(msg.Data))
-			if err != nil {
-				direction = 0
-			}
-			g.RemoteInput = direction
+// logRejects logs the rejected traffic counters every minute they change.
+func logRejects(stats func() network.RejectStats) {
+	var last network.RejectStats
+	for range time.Tick(time.Minute) {
+		if st := stats(); st != last {
+			log.Printf("Rejected: %d rate limited, %d cookie challenges, %d invalid invites, %d locked out, %d unauthenticated, %d banned",
+				st.RateLimited, st.CookieChallenges, st.InvalidInvites, st.LockedOut, st.Unauthenticated, st.Banned)
+			last = st
 		}
+	}
+}
 
-		// Broadcast state updates to all connected clients.
-		go func() {
-			for g.Engine.Running {
-				seq++
-				state := g.GetState()
-				buf := new(bytes.Buffer)
-				binary.Write(buf, binary.BigEndian, state.BallX)
-				binary.Write(buf, binary.BigEndian, state.BallY)
-				binary.Write(buf, binary.BigEndian, state.BallVX)
-				binary.Write(buf, binary.BigEndian, state.BallVY)
-				binary.Write(buf, binary.BigEndian, state.P1X)
-				binary.Write(buf, binary.BigEndian, state.P1Y)
-				binary.Write(buf, binary.BigEndian, state.P2X)
-				binary.Write(buf, binary.BigEndian, state.P2Y)
-				binary.Write(buf, binary.BigEndian, int32(state.ScoreLeft))
-				binary.Write(buf, binary.BigEndian, int32(state.ScoreRight))
-				binary.Write(buf, binary.BigEndian, state.Timestamp)
+// isDone reports whether done has been closed.
+func isDone(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
 
-				msg := network.Message{
-					Type: network.MessageTypeStateUpdate,
-					Seq:  seq,
-					Data: buf.Bytes(),
-				}
-				server.Broadcast(msg)
-				sdl.Delay(10)
-			}
-		}()
+// waitForClients blocks until n clients hold a paddle. It reports false
+// if the server's room was closed first.
+func waitForClients(server *network.Server, n int) bool {
+	for !isDone(server.Done()) {
+		if server.PlayerCount() >= n {
+			return true
+		}
+		time.Sleep(16 * time.Millisecond)
+	}
+	return false
+}
 
-		g.Run()
-	} else if selectedMode == "join" {
-		log.Printf("Joining game with invite code: %s", joinInviteCode)
-		client := network.NewClient("localhost:9000")
-		if err := client.Connect(joinInviteCode); err != nil {
-			log.Printf("Failed to join the game: %v", err)
+// inputUpdateHandler returns a callback that applies an input_update message
+// (msg.Data holds a network.InputCommand) to the sender's paddle. The match
+// clamps the direction and drops inputs with a bad duration or from
+// spectators, so the handler passes them on as received.
+func inputUpdateHandler(server *network.Server, m *sim.Match) func(addr *net.UDPAddr, msg network.Message) {
+	return func(addr *net.UDPAddr, msg network.Message) {
+		in, err := network.DecodeInput(msg.Data)
+		if err != nil {
+			fmt.Println("Error decoding input:", err)
 			return
 		}
+		m.ApplyInput(server.ClientIndex(addr), int(in.Direction), in.DeltaTime, msg.Seq)
+	}
+}
 
-		// Create the game instance. Its state will be updated via server broadcasts.
-		g := game.NewGame(eng)
-
-		// Create a buffered channel for input updates.
-		inputChan := make(chan int, 20)
-
-		// Start a goroutine dedicated to sending input updates without blocking.
-		go func() {
-			for eng.Running {
-				select {
-				case direction := <-inputChan:
-					msg := network.Message{
-						Type: network.MessageTypeInputUpdate,
-						Data: []byte(fmt.Sprintf("%d", direction)),
-					}
-					if err := client.Send(msg); err != nil {
-						fmt.Println("Error sending input update:", err)
-					}
-				default:
-					sdl.Delay(1)
-				}
-			}
-		}()
+// logChat is a server OnChat callback logging the chat of headless matches.
+func logChat(addr *net.UDPAddr, chat network.ChatMessage) {
+	log.Printf("Chat from %s (%s): %s", chat.From, addr, chat.Text)
+}
 
-		// Use a state-update callback to reconcile the host's state with local prediction.
-		client.OnStateUpdate = func(s shared.State) {
-			stateBuffer = append(stateBuffer, s)
+// pauseOnDisconnect pauses the match while either paddle's player is gone
+// and resumes it once both slots are filled again.
+func pauseOnDisconnect(server *network.Server, m *sim.Match) {
+	var mu sync.Mutex
+	missing := make(map[int]bool)
+	server.OnClientLeft = func(addr *net.UDPAddr, slot int, reason network.LeaveReason) {
+		if slot != 0 && slot != 1 {
+			return
 		}
-
-		// Run a combined render and input loop.
-		lastRender := time.Now()
-
-		for eng.Running {
-			for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
-				switch ev := event.(type) {
-				case *sdl.QuitEvent:
-					eng.Running = false
-				case *sdl.KeyboardEvent:
-					// Capture UP and DOWN events.
-					if ev.Keysym.Sym == sdl.K_UP || ev.Keysym.Sym == sdl.K_DOWN {
-						var direction int
-						if ev.Type == sdl.KEYDOWN {
-							if ev.Keysym.Sym == sdl.K_UP {
-								direction = -1
-							} else if ev.Keysym.Sym == sdl.K_DOWN {
-								direction = 1
-							}
-						} else if ev.Type == sdl.KEYUP {
-							direction = 0
-						}
-						// Immediately update the local paddle (client-side prediction).
-						g.Player2.Y += float32(direction) * g.Player2.Speed * (10.0 / 1000.0)
-						if g.Player2.Y < 0 {
-							g.Player2.Y = 0
-						} else if g.Player2.Y+float32(g.Player2.Height) > 600 {
-							g.Player2.Y = 600 - float32(g.Player2.Height)
-						}
-						// Non-blocking send to the input channel.
-						select {
-						case inputChan <- direction:
-						default:
-							// If the channel is full, drop the input update.
-						}
-					}
-				}
-			}
-			// Use an adaptive render delay based on measuredRTT (set by your ping/pong routine).
-			adaptiveDelay := defaultRenderDelay
-			if network.MeasuredRTT > 0 {
-				adaptiveDelay = network.MeasuredRTT / 2
-			}
-
-			if len(stateBuffer) >= 2 {
-				renderTime := time.Now().UnixNano() - adaptiveDelay
-
-				// Find the two states surrounding renderTime.
-				var s1, s2 shared.State
-				for i := 0; i < len(stateBuffer)-1; i++ {
-					if stateBuffer[i].Timestamp <= renderTime && renderTime <= stateBuffer[i+1].Timestamp {
-						s1 = stateBuffer[i]
-						s2 = stateBuffer[i+1]
-						break
-					}
-				}
-				// Perform interpolation.
-				duration := s2.Timestamp - s1.Timestamp
-				if duration > 0 {
-					t := float32(renderTime-s1.Timestamp) / float32(duration)
-					interpolatedState := shared.InterpolateState(s1, s2, t)
-					// For client, update only remote objects (ignore Paddle2 which is controlled locally).
-					if selectedMode == "join" {
-						g.ApplyRemoteState(interpolatedState, true)
-					} else {
-						g.SetStateSmooth(interpolatedState)
-					}
-				}
-			}
-
-			// Update FPS calculation.
-			now := time.Now()
-			dt := now.Sub(lastRender)
-			fps := 1.0 / dt.Seconds()
-			lastRender = now
-
-			// Clear screen and render game.
-			eng.Clear()
-			g.Render()
-
-			// Compose overlay text (convert ping from nanoseconds to ms).
-			pingMs := int64(0)
-			if network.MeasuredRTT > 0 {
-				pingMs = network.MeasuredRTT / 1e6
-			}
-			infoText := fmt.Sprintf("FPS: %.0f  Ping: %d ms", fps, pingMs)
-
-			// Render the overlay text (e.g. at top-left).
-			if err := renderText(eng.Renderer, font, infoText, 10, 10); err != nil {
-				fmt.Println("Error rendering info text:", err)
-			}
-
-			// Present the updated frame.
-			eng.Present()
-			sdl.Delay(16)
+		mu.Lock()
+		defer mu.Unlock()
+		missing[slot] = true
+		m.SetPaused(true)
+		log.Printf("Player %d (%s) %s, match paused", slot+1, addr, reason)
+	}
+	server.OnClientJoined = func(addr *net.UDPAddr, slot int) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !missing[slot] {
+			return
+		}
+		delete(missing, slot)
+		if len(missing) == 0 {
+			m.SetPaused(false)
+			log.Printf("Player %d (%s) joined, match resumed", slot+1, addr)
 		}
 	}
 }
 
-func pointInRect(x, y int32, r sdl.Rect) bool {
-	return x >= r.X && x <= (r.X+r.W) && y >= r.Y && y <= (r.Y+r.H)
-}
-
-func drawButton(renderer *sdl.Renderer, rect sdl.Rect, label string) {
-	// Draw a simple colored button.
-	renderer.SetDrawColor(100, 100, 255, 255)
-	renderer.FillRect(&rect)
-	// (Optionally, render the label using a text library such as SDL_ttf.)
+// broadcastState sends the game state to all connected clients every 10ms
+// for as long as running reports true. Match start, score changes and match
+// end are additionally sent as reliable events.
+func broadcastState(server *network.Server, m *sim.Match, running func() bool) {
+	server.Broadcast(network.Message{Type: network.MessageTypeMatchStart})
+	defer server.Broadcast(network.Message{Type: network.MessageTypeMatchEnd})
+
+	scoreLeft, scoreRight := 0, 0
+	for running() {
+		state := m.GetState()
+		if state.ScoreLeft != scoreLeft || state.ScoreRight != scoreRight {
+			scoreLeft, scoreRight = state.ScoreLeft, state.ScoreRight
+			server.Broadcast(network.Message{
+				Type: network.MessageTypeScore,
+				Data: network.EncodeScore(scoreLeft, scoreRight),
+			})
+		}
+		server.BroadcastState(state)
+		time.Sleep(10 * time.Millisecond)
+	}
 }
 
 func generateInviteCode() string {
@@ -382,19 +317,3 @@ func generateInviteCode() string {
 	}
 	return string(code)
 }
-
-func renderText(renderer *sdl.Renderer, font *ttf.Font, text string, x, y int32) error {
-	color := sdl.Color{R: 255, G: 255, B: 255, A: 255}
-	surface, err := font.RenderUTF8Solid(text, color)
-	if err != nil {
-		return err
-	}
-	defer surface.Free()
-	texture, err := renderer.CreateTextureFromSurface(surface)
-	if err != nil {
-		return err
-	}
-	defer texture.Destroy()
-	rect := sdl.Rect{X: x, Y: y, W: surface.W, H: surface.H}
-	return renderer.Copy(texture, nil, &rect)
-}
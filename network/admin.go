@@ -0,0 +1,275 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientInfo describes a connected client.
+type ClientInfo struct {
+	Addr  *net.UDPAddr
+	Role  Role
+	Stats Stats
+}
+
+// ListClients returns the connected clients, players first.
+func (s *Server) ListClients() []ClientInfo {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+	clients := make([]ClientInfo, 0, len(s.sessions))
+	for key, sess := range s.sessions {
+		clients = append(clients, ClientInfo{
+			Addr:  sess.addr,
+			Role:  roleForSlot(s.clientIndexLocked(key)),
+			Stats: sess.stats.get(),
+		})
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].Role != clients[j].Role {
+			return clients[i].Role < clients[j].Role
+		}
+		return clients[i].Addr.String() < clients[j].Addr.String()
+	})
+	return clients
+}
+
+// InviteCode returns the code clients must present.
+func (s *Server) InviteCode() string {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+	return s.ExpectedInviteCode
+}
+
+// SetInviteCode changes the code new clients must present. Connected
+// clients stay, but reconnecting requires the new code. The rooms of a
+// lobby are found by their code and keep it.
+func (s *Server) SetInviteCode(code string) {
+	s.Lock.Lock()
+	s.ExpectedInviteCode = code
+	s.Lock.Unlock()
+}
+
+// Kick tells the client at addr, as listed by ListClients, that it has
+// been removed and frees its slot for good.
+func (s *Server) Kick(addr string) error {
+	s.Lock.Lock()
+	sess, ok := s.sessions[addr]
+	s.Lock.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown client %s", addr)
+	}
+	bye, _ := EncodeMessage(Message{Type: MessageTypeDisconnect})
+	sess.secure.send(bye)
+	s.removeClient(addr, LeaveReasonKicked)
+	return nil
+}
+
+// Ban drops all traffic from ip from now on and kicks its clients. It
+// returns how many were kicked. Clients in this process, such as the
+// host's own player, are exempt. The rooms of a lobby share their bans.
+func (s *Server) Ban(ip net.IP) int {
+	s.guard.ban(ip, true)
+	var kicked []string
+	s.Lock.Lock()
+	for key, sess := range s.sessions {
+		if sess.addr.IP.Equal(ip) && !sess.local {
+			kicked = append(kicked, key)
+		}
+	}
+	s.Lock.Unlock()
+	for _, key := range kicked {
+		s.Kick(key)
+	}
+	return len(kicked)
+}
+
+// Unban admits traffic from ip again.
+func (s *Server) Unban(ip net.IP) {
+	s.guard.ban(ip, false)
+}
+
+// AdminConsole serves a line-based console for managing a live match on a
+// loopback TCP address, e.g. with nc 127.0.0.1 9100. Type help for the
+// commands.
+type AdminConsole struct {
+	Address string
+	Server  *Server
+	// OnPause, OnResume and OnReset control the match, and OnEnd ends it.
+	// The commands of callbacks left nil are unavailable.
+	OnPause, OnResume, OnReset, OnEnd func()
+
+	done      chan struct{}
+	closeOnce sync.Once
+	conns     sync.WaitGroup
+}
+
+func NewAdminConsole(address string, server *Server) *AdminConsole {
+	return &AdminConsole{Address: address, Server: server, done: make(chan struct{})}
+}
+
+// Start serves the console until it is closed, by Close or by ctx being
+// done. Only loopback addresses are accepted, as the console has no
+// authentication of its own.
+func (a *AdminConsole) Start(ctx context.Context) error {
+	addr, err := net.ResolveTCPAddr("tcp", a.Address)
+	if err != nil {
+		return err
+	}
+	if !addr.IP.IsLoopback() {
+		return fmt.Errorf("admin console address %s is not a loopback address", a.Address)
+	}
+	ln, err := net.ListenTCP("tcp", addr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Admin console on %s\n", ln.Addr())
+
+	var mu sync.Mutex
+	open := make(map[net.Conn]bool)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-a.done:
+		}
+		// Closing the listener and connections ends their loops.
+		ln.Close()
+		mu.Lock()
+		for conn := range open {
+			conn.Close()
+		}
+		mu.Unlock()
+	}()
+	defer a.conns.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			fmt.Println("Error accepting admin connection:", err)
+			continue
+		}
+		mu.Lock()
+		open[conn] = true
+		mu.Unlock()
+		a.conns.Add(1)
+		go func() {
+			defer a.conns.Done()
+			a.serve(conn, conn)
+			conn.Close()
+			mu.Lock()
+			delete(open, conn)
+			mu.Unlock()
+		}()
+	}
+}
+
+// Close stops the console and disconnects its users.
+func (a *AdminConsole) Close() error {
+	a.closeOnce.Do(func() { close(a.done) })
+	return nil
+}
+
+// serve runs commands read from r, writing their output to w, until quit
+// or the end of r.
+func (a *AdminConsole) serve(r io.Reader, w io.Writer) {
+	fmt.Fprint(w, "Pong admin console. Type help for the commands.\n> ")
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 1 && fields[0] == "quit" {
+			return
+		}
+		if len(fields) > 0 {
+			fmt.Fprint(w, a.exec(fields[0], fields[1:]))
+		}
+		fmt.Fprint(w, "> ")
+	}
+}
+
+// adminHelp describes the console's commands.
+const adminHelp = `list               list clients with address, role, RTT and loss
+kick ADDR          remove the client at ADDR
+ban IP|ADDR        kick all clients from IP and drop its traffic
+unban IP           admit traffic from IP again
+pause, resume      pause or resume the match
+reset              reset the score, ball and paddles
+invite [CODE]      show or change the invite code; "invite -" removes it
+end                end the match
+quit               leave the console
+`
+
+// exec runs one command and returns its output.
+func (a *AdminConsole) exec(cmd string, args []string) string {
+	callback := map[string]func(){"pause": a.OnPause, "resume": a.OnResume, "reset": a.OnReset, "end": a.OnEnd}
+	switch cmd {
+	case "help":
+		return adminHelp
+	case "list":
+		var b strings.Builder
+		fmt.Fprintf(&b, "%-22s %-13s %8s %6s\n", "ADDRESS", "ROLE", "RTT", "LOSS")
+		for _, c := range a.Server.ListClients() {
+			fmt.Fprintf(&b, "%-22s %-13s %8v %5.1f%%\n", c.Addr, c.Role, c.Stats.RTT.Round(time.Millisecond), 100*c.Stats.Loss())
+		}
+		return b.String()
+	case "kick":
+		if len(args) != 1 {
+			return "usage: kick ADDR\n"
+		}
+		if err := a.Server.Kick(args[0]); err != nil {
+			return fmt.Sprintf("error: %v\n", err)
+		}
+		return fmt.Sprintf("kicked %s\n", args[0])
+	case "ban", "unban":
+		if len(args) != 1 {
+			return fmt.Sprintf("usage: %s IP\n", cmd)
+		}
+		ip := parseAdminIP(args[0])
+		if ip == nil {
+			return fmt.Sprintf("error: %q is not an IP address\n", args[0])
+		}
+		if cmd == "unban" {
+			a.Server.Unban(ip)
+			return fmt.Sprintf("unbanned %s\n", ip)
+		}
+		return fmt.Sprintf("banned %s, kicked %d clients\n", ip, a.Server.Ban(ip))
+	case "invite":
+		switch {
+		case len(args) == 0:
+			if code := a.Server.InviteCode(); code != "" {
+				return fmt.Sprintf("invite code: %s\n", code)
+			}
+			return "no invite code\n"
+		case len(args) == 1 && args[0] == "-":
+			a.Server.SetInviteCode("")
+			return "invite code removed\n"
+		case len(args) == 1:
+			a.Server.SetInviteCode(args[0])
+			return fmt.Sprintf("invite code changed to %s\n", args[0])
+		}
+		return "usage: invite [CODE]\n"
+	case "pause", "resume", "reset", "end":
+		if callback[cmd] == nil {
+			return fmt.Sprintf("error: %s is not available here\n", cmd)
+		}
+		callback[cmd]()
+		return "ok\n"
+	}
+	return fmt.Sprintf("unknown command %q, type help for the commands\n", cmd)
+}
+
+// parseAdminIP parses an IP address, or the IP of an address with a port.
+func parseAdminIP(s string) net.IP {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	return net.ParseIP(s)
+}
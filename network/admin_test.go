@@ -0,0 +1,137 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"pong-multiplayer/sim"
+)
+
+// startAdmin serves an admin console for server on a free loopback port
+// and returns a connection to it; both are closed when the test ends.
+func startAdmin(t *testing.T, server *Server, setup func(a *AdminConsole)) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+	console := NewAdminConsole(address, server)
+	setup(console)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- console.Start(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Start: %v", err)
+		}
+	})
+	var conn net.Conn
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if conn, err = net.Dial("tcp", address); err == nil || time.Now().After(deadline) {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn, bufio.NewReader(conn)
+}
+
+// adminCommand sends line to the console and returns its output, up to
+// the next prompt.
+func adminCommand(t *testing.T, conn net.Conn, r *bufio.Reader, line string) string {
+	t.Helper()
+	if _, err := conn.Write([]byte(line + "\n")); err != nil {
+		t.Fatalf("sending %q: %v", line, err)
+	}
+	return readPrompt(t, r)
+}
+
+// readPrompt reads the console's output up to its next prompt.
+func readPrompt(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	var out strings.Builder
+	for !strings.HasSuffix(out.String(), "> ") {
+		b, err := r.ReadByte()
+		if err != nil {
+			t.Fatalf("reading the console: %v after %q", err, out.String())
+		}
+		out.WriteByte(b)
+	}
+	return strings.TrimSuffix(out.String(), "> ")
+}
+
+func TestAdminConsole(t *testing.T) {
+	server, mem := startMatch(t, nil)
+	client, err := joinMatch(t, mem, "ABC123", nil)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	// The match runs and is drawn on its own goroutine, as in a window,
+	// while the console pauses and resets it.
+	m := sim.NewMatch()
+	m.ScoreLeft = 3
+	drawing := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for !isClosed(drawing) {
+			m.Advance(sim.TickDuration)
+			m.Positions()
+		}
+	}()
+	conn, r := startAdmin(t, server, func(a *AdminConsole) {
+		a.OnPause = func() { m.SetPaused(true) }
+		a.OnReset = m.Reset
+	})
+	readPrompt(t, r)
+
+	addr := client.Conn.LocalAddr().String()
+	tests := []struct {
+		line, want string
+	}{
+		{"help", "reset the score, ball and paddles"},
+		{"list", fmt.Sprintf("%-22s %-13s", addr, RolePlayerLeft)},
+		{"pause", "ok"},
+		{"reset", "ok"},
+		{"resume", "error: resume is not available here"},
+		{"invite", "invite code: ABC123"},
+		{"invite XYZ789", "invite code changed to XYZ789"},
+		{"ban nonsense", "is not an IP address"},
+		{"kick 10.0.0.1:1", "error: unknown client"},
+		{"kick " + addr, "kicked " + addr},
+		{"frobnicate", "unknown command"},
+	}
+	for _, tt := range tests {
+		if got := adminCommand(t, conn, r, tt.line); !strings.Contains(got, tt.want) {
+			t.Errorf("%s: got %q, want it to contain %q", tt.line, got, tt.want)
+		}
+	}
+	close(drawing)
+	<-stopped
+	if left, right := m.Score(); !m.Paused() || left != 0 || right != 0 {
+		t.Errorf("match paused %v with score %d-%d, want paused at 0-0", m.Paused(), left, right)
+	}
+	if code := server.InviteCode(); code != "XYZ789" {
+		t.Errorf("InviteCode = %q, want XYZ789", code)
+	}
+	if n := server.PlayerCount(); n != 0 {
+		t.Errorf("%d players after the kick, want 0", n)
+	}
+
+	// quit ends the session.
+	conn.Write([]byte("quit\n"))
+	if _, err := r.ReadByte(); err == nil {
+		t.Error("console still open after quit")
+	}
+}
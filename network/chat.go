@@ -0,0 +1,92 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// MaxChatLength is the most characters a chat message may have; the
+	// server cuts longer ones short.
+	MaxChatLength = 120
+	// chatRate and chatBurst limit how many chat messages per second each
+	// sender may have relayed; the server drops the rest.
+	chatRate  = 1
+	chatBurst = 5
+)
+
+// ChatMessage is a chat line as the server relays it.
+type ChatMessage struct {
+	// From names the sender's role in the match.
+	From string
+	Text string
+}
+
+// sanitizeChat makes text fit for display: valid UTF-8 on a single line,
+// without surrounding space and at most MaxChatLength characters long.
+func sanitizeChat(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(text, ""))
+	text = strings.TrimSpace(text)
+	if runes := []rune(text); len(runes) > MaxChatLength {
+		text = strings.TrimSpace(string(runes[:MaxChatLength]))
+	}
+	return text
+}
+
+// EncodeChat produces the payload of a MessageTypeChat message relayed by
+// the server: the length of From as 1 byte, From, then Text.
+func EncodeChat(chat ChatMessage) []byte {
+	from := chat.From
+	if len(from) > 255 {
+		from = from[:255]
+	}
+	data := append([]byte{byte(len(from))}, from...)
+	return append(data, chat.Text...)
+}
+
+// DecodeChat converts a MessageTypeChat payload relayed by the server back
+// into a ChatMessage.
+func DecodeChat(data []byte) (ChatMessage, error) {
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return ChatMessage{}, fmt.Errorf("chat payload too short: %d bytes", len(data))
+	}
+	n := 1 + int(data[0])
+	return ChatMessage{From: string(data[1:n]), Text: sanitizeChat(string(data[n:]))}, nil
+}
+
+// Chat sends a chat message to everyone in the match, the sender included.
+// Its payload is just the text.
+func (c *Client) Chat(text string) error {
+	text = sanitizeChat(text)
+	if text == "" {
+		return nil
+	}
+	return c.Send(Message{Type: MessageTypeChat, Data: []byte(text)})
+}
+
+// handleChat relays a chat message to every client, naming the sender by
+// its role. Empty messages and those beyond the sender's rate are dropped.
+func (s *Server) handleChat(addr *net.UDPAddr, msg Message) {
+	text := sanitizeChat(string(msg.Data))
+	s.Lock.Lock()
+	sess, ok := s.sessions[addr.String()]
+	allowed := ok && text != "" && sess.chat.take(time.Now(), chatRate, chatBurst)
+	from := roleForSlot(s.clientIndexLocked(addr.String())).String()
+	s.Lock.Unlock()
+	if !allowed {
+		return
+	}
+	chat := ChatMessage{From: from, Text: text}
+	s.Broadcast(Message{Type: MessageTypeChat, Data: EncodeChat(chat)})
+	if s.OnChat != nil {
+		s.OnChat(addr, chat)
+	}
+}
@@ -0,0 +1,93 @@
+package network
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSanitizeChat(t *testing.T) {
+	tests := []struct {
+		name, text, want string
+	}{
+		{"plain", "good game", "good game"},
+		{"surrounding space", "  hi \t", "hi"},
+		{"newlines", "line one\nline two\r\n", "line oneline two"},
+		{"escape sequence", "\x1b[31mred\x1b[0m", "[31mred[0m"},
+		{"invalid UTF-8", "ok\xff\xfe!", "ok!"},
+		{"only control characters", "\x00\x07\n", ""},
+		{"at the limit", strings.Repeat("a", MaxChatLength), strings.Repeat("a", MaxChatLength)},
+		{"over the limit", strings.Repeat("a", MaxChatLength+10), strings.Repeat("a", MaxChatLength)},
+		// The limit counts characters, not bytes.
+		{"multibyte over the limit", strings.Repeat("é", MaxChatLength+1), strings.Repeat("é", MaxChatLength)},
+		{"space at the cut", strings.Repeat("a", MaxChatLength-1) + " tail", strings.Repeat("a", MaxChatLength-1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeChat(tt.text); got != tt.want {
+				t.Errorf("sanitizeChat(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatRoundTrip(t *testing.T) {
+	chat := ChatMessage{From: "Left player", Text: "hi"}
+	got, err := DecodeChat(EncodeChat(chat))
+	if err != nil || got != chat {
+		t.Errorf("DecodeChat = %+v, %v; want %+v", got, err, chat)
+	}
+	// Relayed text is sanitized again on the way in.
+	data := append(EncodeChat(ChatMessage{From: "x"}), "bad\nline"+strings.Repeat("!", MaxChatLength)...)
+	if got, err := DecodeChat(data); err != nil || len(got.Text) != MaxChatLength || strings.ContainsRune(got.Text, '\n') {
+		t.Errorf("DecodeChat = %q, %v; want %d characters on one line", got.Text, err, MaxChatLength)
+	}
+	for _, data := range [][]byte{nil, {5, 'a'}} {
+		if _, err := DecodeChat(data); err == nil {
+			t.Errorf("DecodeChat(%q) succeeded", data)
+		}
+	}
+}
+
+func TestChatRateLimit(t *testing.T) {
+	relayed := make(chan ChatMessage, 20)
+	_, mem := startMatch(t, func(s *Server) {
+		s.OnChat = func(addr *net.UDPAddr, chat ChatMessage) { relayed <- chat }
+	})
+	client, err := joinMatch(t, mem, "ABC123", nil)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	// expect waits for n relayed messages, then makes sure no more follow.
+	expect := func(n int) {
+		t.Helper()
+		for i := range n {
+			select {
+			case <-relayed:
+			case <-time.After(time.Second):
+				t.Fatalf("only %d of %d messages relayed", i, n)
+			}
+		}
+		select {
+		case chat := <-relayed:
+			t.Fatalf("%q relayed beyond the rate limit", chat.Text)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+
+	// A burst goes through, then the rest is dropped.
+	for range chatBurst + 3 {
+		if err := client.Chat("spam"); err != nil {
+			t.Fatalf("Chat: %v", err)
+		}
+	}
+	expect(chatBurst)
+
+	// One more message a second is let through after that.
+	time.Sleep(time.Second / chatRate)
+	for range 2 {
+		client.Chat("again")
+	}
+	expect(1)
+}
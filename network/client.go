@@ -2,83 +2,234 @@ package network
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"pong-multiplayer/shared"
 )
 
-var MeasuredRTT int64
-
-func setMeasuredRTT(rtt int64) {
-	atomic.StoreInt64(&MeasuredRTT, rtt)
-}
+// handshakeRetryInterval is how long Connect waits for a handshake reply
+// before sending the handshake again.
+const handshakeRetryInterval = 500 * time.Millisecond
 
 type StateUpdateCallback func(state shared.State)
 
 type Client struct {
 	Address       string
 	RemoteAddr    *net.UDPAddr
-	Conn          *net.UDPConn
+	Conn          Conn
 	OnStateUpdate StateUpdateCallback
+	// Role is the part assigned by the server during the handshake.
+	Role Role
+	// Capabilities is the set negotiated with the server during the handshake.
+	Capabilities Capability
+	// OnMessage is called for message types without a handler registered
+	// with Handle, including those delivered over the reliable channel.
+	OnMessage func(msg Message)
+	// OnDisconnect is called when the server ends the session, because an
+	// admin kicked or banned this client.
+	OnDisconnect func()
+	// LocalAddr is the local address to send from, or nil to let the system
+	// choose. ConnectVia sets it to the port its NAT mapping was made for.
+	LocalAddr *net.UDPAddr
+	// Token is the session token returned by the server, presented by
+	// Reconnect to reclaim the same slot.
+	Token SessionToken
+	// Transport opens the client's socket; NewClient sets UDPTransport.
+	Transport Transport
+	// Conditions simulate a bad network on the client's socket.
+	Conditions Conditions
+	// MTU is the largest datagram sent to the server; larger messages are
+	// fragmented. NewClient sets DefaultMTU.
+	MTU int
+	// Clock tracks the server's clock from ping/pong exchanges. State
+	// timestamps are mapped to the local clock with it before
+	// OnStateUpdate sees them.
+	Clock *ClockSync
+
+	handlers   handlerRegistry[ClientHandler]
+	inviteCode string
+	// mu guards the current connection and the exported fields set by the
+	// handshake, which Reconnect replaces while the goroutines of the old
+	// connection may still be running.
+	mu      sync.Mutex
+	current *connection
+	// loops counts the goroutines of the current connection, which Close
+	// waits for.
+	loops sync.WaitGroup
+	// lastHeard is when the last authenticated datagram arrived, in Unix
+	// nanoseconds.
+	lastHeard    atomic.Int64
+	lastStateSeq uint32
+	snapshots    snapshotHistory
+	stats        connStats
+}
+
+// connection is the state of one connection to the server. It never
+// changes; Reconnect replaces it as a whole.
+type connection struct {
+	conn         Conn
+	secure       *secureChannel
+	reliable     *ReliableChannel
+	capabilities Capability
+	// done is closed when the connection is closed.
+	done chan struct{}
 }
 
+// errNotConnected is returned when sending without a connection.
+var errNotConnected = errors.New("not connected")
+
 func NewClient(address string) *Client {
-	return &Client{
-		Address: address,
+	c := &Client{
+		Address:   address,
+		Clock:     &ClockSync{},
+		Transport: UDPTransport{},
+		MTU:       DefaultMTU,
 	}
+	c.registerHandlers()
+	return c
+}
+
+// Connect performs the handshake with the server and starts exchanging
+// messages in the background. ctx only bounds the handshake; once
+// connected, the connection lasts until Close.
+func (c *Client) Connect(ctx context.Context, inviteCode string) error {
+	c.inviteCode = inviteCode
+	return c.connect(ctx)
+}
+
+// Reconnect replaces the connection with a fresh socket and handshake,
+// presenting the session token so that the server hands back the same slot
+// and resumes the match where it left off.
+func (c *Client) Reconnect(ctx context.Context) error {
+	c.Close()
+	return c.connect(ctx)
 }
 
-func (c *Client) Connect(inviteCode string) error {
+// Close stops the client's goroutines and closes its socket, returning once
+// the goroutines have stopped; it must not be called from the client's
+// callbacks. It doesn't tell the server; call Disconnect first for that.
+// Closing a closed client does nothing.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	cur := c.current
+	c.current = nil
+	c.Conn = nil
+	c.mu.Unlock()
+	if cur == nil {
+		return nil
+	}
+	close(cur.done)
+	err := cur.conn.Close()
+	c.loops.Wait()
+	return err
+}
+
+// connection returns the current connection, or nil if there is none.
+func (c *Client) connection() *connection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current
+}
+
+// LastHeard returns when the last authenticated datagram from the server
+// arrived, or the handshake completed. The server pings every second, so a
+// longer silence means the connection is lost even while no match is being
+// played.
+func (c *Client) LastHeard() time.Time {
+	return time.Unix(0, c.lastHeard.Load())
+}
+
+// Slot returns the paddle controlled in the role assigned by the latest
+// handshake, or -1 for a spectator. Unlike Role, it may be read while
+// Reconnect runs on another goroutine.
+func (c *Client) Slot() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Role.Slot()
+}
+
+func (c *Client) connect(ctx context.Context) error {
 	// Resolve the server address.
 	serverAddr, err := net.ResolveUDPAddr("udp", c.Address)
 	if err != nil {
 		return err
 	}
-	// Create a UDP connection (letting the system choose a local port).
-	conn, err := net.DialUDP("udp", nil, serverAddr)
+	nonce, err := newNonce()
 	if err != nil {
 		return err
 	}
-	c.Conn = conn
-	c.RemoteAddr = serverAddr
-
-	// Send handshake message.
-	handshake := Message{
-		Type: MessageTypeHandshake,
-		Data: []byte(inviteCode),
-	}
-	encoded, err := EncodeMessage(handshake)
+	// Create a connection (letting the transport choose a local port
+	// unless LocalAddr is set).
+	dialed, err := c.Transport.Dial(c.LocalAddr, c.Address)
 	if err != nil {
 		return err
 	}
-	if _, err = c.Conn.Write(encoded); err != nil {
-		return err
+	conn := Condition(dialed, c.Conditions)
+
+	// Send handshake message announcing our protocol version and capabilities.
+	deadline := time.Now().Add(5 * time.Second)
+	if d, ok := ctx.Deadline(); ok {
+		deadline = minTime(deadline, d)
 	}
-	// Set a read deadline for the handshake response.
-	c.Conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-	buf := make([]byte, 1024)
-	n, _, err := c.Conn.ReadFromUDP(buf)
+	reply, err := handshake(ctx, conn, Handshake{
+		ProtocolVersion: ProtocolVersion,
+		BuildID:         BuildID,
+		Capabilities:    SupportedCapabilities,
+		SessionToken:    c.Token,
+		Nonce:           nonce,
+	}, c.inviteCode, deadline)
 	if err != nil {
-		return fmt.Errorf("failed to receive handshake response: %v", err)
+		conn.Close()
+		return err
 	}
-	data := buf[:n]
-	msg, err := DecodeMessage(data)
+	// From here on every datagram is sealed with the session key.
+	key := deriveSessionKey(c.inviteCode, nonce, reply.Nonce)
+	secure, err := newSecureChannel(key, reply.Capabilities.Has(CapabilityEncryption), false, c.MTU, &c.stats, func(data []byte) error {
+		_, err := conn.Write(data)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to decode handshake response: %v", err)
+		conn.Close()
+		return err
 	}
-	if msg.Type == MessageTypeError {
-		return fmt.Errorf("handshake error: %s", string(msg.Data))
+	// A new session, rather than one resumed with the token, comes from a
+	// server counting its snapshots afresh, which the baselines and the
+	// last sequence number of the old one would hold back.
+	if reply.SessionToken != c.Token {
+		c.lastStateSeq = 0
+		c.snapshots = snapshotHistory{}
 	}
-	// Reset deadline.
-	c.Conn.SetReadDeadline(time.Time{})
-	go c.listen()
+	// The server starts the new session with a fresh reliable channel.
+	reliable := NewReliableChannel(secure.send)
+	done := make(chan struct{})
+	cur := &connection{conn: conn, secure: secure, reliable: reliable, capabilities: reply.Capabilities, done: done}
+	c.mu.Lock()
+	c.current = cur
+	c.Conn = conn
+	c.RemoteAddr = serverAddr
+	c.Role = reply.Role
+	c.Capabilities = reply.Capabilities
+	c.Token = reply.SessionToken
+	c.mu.Unlock()
+	c.lastHeard.Store(time.Now().UnixNano())
+	c.goLoop(func() { c.listen(cur) })
+
+	// Retransmit unacknowledged reliable messages.
+	c.goLoop(func() {
+		for wait(done, reliableResendInterval/2) {
+			reliable.Resend(time.Now())
+		}
+	})
 
 	// Start sending periodic pings
-	go func() {
+	c.goLoop(func() {
 		var pingSeq uint32 = 0
 		for {
 			pingSeq++
@@ -94,24 +245,37 @@ func (c *Client) Connect(inviteCode string) error {
 			if err != nil {
 				fmt.Println("Error encoding ping:", err)
 			} else {
-				_, err = c.Conn.Write(encoded)
-				if err != nil {
+				err = secure.send(encoded)
+				if err != nil && !isClosed(done) {
 					fmt.Println("Error sending ping:", err)
 				}
 			}
-			time.Sleep(1 * time.Second)
+			if !wait(done, time.Second) {
+				return
+			}
 		}
-	}()
+	})
 
 	return nil
 }
 
-func (c *Client) listen() {
-	var lastSeq uint32 = 0
-	buf := make([]byte, 1024)
+// goLoop runs loop in a goroutine that Close waits for.
+func (c *Client) goLoop(loop func()) {
+	c.loops.Add(1)
+	go func() {
+		defer c.loops.Done()
+		loop()
+	}()
+}
+
+func (c *Client) listen(cur *connection) {
+	buf := make([]byte, maxDatagramSize)
 	for {
-		n, _, err := c.Conn.ReadFromUDP(buf)
+		n, _, err := cur.conn.ReadFromUDP(buf)
 		if err != nil {
+			if isClosed(cur.done) || errors.Is(err, net.ErrClosed) {
+				return
+			}
 			fmt.Println("Error reading from UDP:", err)
 			continue
 		}
@@ -122,99 +286,274 @@ func (c *Client) listen() {
 			fmt.Println("Error decoding message:", err)
 			continue
 		}
-		if msg.Type == MessageTypeStateUpdate {
-			// Discard if packet is older than the last processed one.
-			if msg.Seq <= lastSeq {
-				continue
-			}
-			lastSeq = msg.Seq
-
-			var state shared.State
-			reader := bytes.NewReader(msg.Data)
-			var ballX, ballY, ballVX, ballVY, p1X, p1Y, p2X, p2Y float32
-			var scoreLeft, scoreRight int32
-			if err := binary.Read(reader, binary.BigEndian, &ballX); err != nil {
-				fmt.Println("binary read error:", err)
-				continue
-			}
-			if err := binary.Read(reader, binary.BigEndian, &ballY); err != nil {
-				fmt.Println("binary read error:", err)
-				continue
-			}
-			if err := binary.Read(reader, binary.BigEndian, &ballVX); err != nil {
-				fmt.Println("binary read error:", err)
-				continue
-			}
-			if err := binary.Read(reader, binary.BigEndian, &ballVY); err != nil {
-				fmt.Println("binary read error:", err)
-				continue
-			}
-			if err := binary.Read(reader, binary.BigEndian, &p1X); err != nil {
-				fmt.Println("binary read error:", err)
-				continue
-			}
-			if err := binary.Read(reader, binary.BigEndian, &p1Y); err != nil {
-				fmt.Println("binary read error:", err)
-				continue
-			}
-			if err := binary.Read(reader, binary.BigEndian, &p2X); err != nil {
-				fmt.Println("binary read error:", err)
-				continue
-			}
-			if err := binary.Read(reader, binary.BigEndian, &p2Y); err != nil {
-				fmt.Println("binary read error:", err)
-				continue
-			}
-			if err := binary.Read(reader, binary.BigEndian, &scoreLeft); err != nil {
-				fmt.Println("binary read error:", err)
-				continue
-			}
-			if err := binary.Read(reader, binary.BigEndian, &scoreRight); err != nil {
-				fmt.Println("binary read error:", err)
-				continue
-			}
-			// Read the timestamp (int64)
-			var timestamp int64
-			if err := binary.Read(reader, binary.BigEndian, &timestamp); err != nil {
-				fmt.Println("binary read error:", err)
-				continue
-			}
-			state = shared.State{
-				BallX:      ballX,
-				BallY:      ballY,
-				BallVX:     ballVX,
-				BallVY:     ballVY,
-				P1X:        p1X,
-				P1Y:        p1Y,
-				P2X:        p2X,
-				P2Y:        p2Y,
-				ScoreLeft:  int(scoreLeft),
-				ScoreRight: int(scoreRight),
-				Timestamp:  timestamp,
-			}
-			if c.OnStateUpdate != nil {
-				c.OnStateUpdate(state)
-			}
-		} else if msg.Type == MessageTypePong {
-			var sentTime int64
-			reader := bytes.NewReader(msg.Data)
-			if err := binary.Read(reader, binary.BigEndian, &sentTime); err == nil {
-				rtt := time.Now().UnixNano() - sentTime
-				// Update a global RTT variable (using atomic operations in production)
-				setMeasuredRTT(rtt) // implement this function as needed
-			}
+		// Only sealed messages are trusted; anything else is a late
+		// handshake reply or left over from NAT traversal.
+		if msg.Type != MessageTypeSecure {
+			continue
+		}
+		inner, err := cur.secure.open(msg)
+		if err != nil {
+			fmt.Println("Dropping datagram:", err)
 			continue
-		} else {
-			fmt.Printf("Received unknown message type: %d\n", msg.Type)
 		}
+		c.lastHeard.Store(time.Now().UnixNano())
+		c.handleMessage(inner)
+	}
+}
+
+// handleMessage dispatches an authenticated message from the server to the
+// handler registered for its type.
+func (c *Client) handleMessage(msg Message) {
+	if h, ok := c.handlers.get(msg.Type); ok {
+		h(msg)
+		return
+	}
+	if c.OnMessage != nil {
+		c.OnMessage(msg)
+	} else {
+		fmt.Printf("Received unknown message type: %d\n", msg.Type)
+	}
+}
+
+// registerHandlers registers the handlers of the message types the client
+// handles itself.
+func (c *Client) registerHandlers() {
+	c.Handle(MessageTypeStateUpdate, c.handleState)
+	c.Handle(MessageTypeStateDelta, c.handleState)
+	c.Handle(MessageTypePong, c.handlePong)
+	c.Handle(MessageTypePing, c.handlePing)
+	c.Handle(MessageTypeFragment, c.handleFragment)
+	c.Handle(MessageTypeReliable, c.handleReliable)
+	c.Handle(MessageTypeAck, c.handleAck)
+	c.Handle(MessageTypeDisconnect, c.handleDisconnect)
+}
+
+func (c *Client) handleState(msg Message) {
+	cur := c.connection()
+	if cur == nil {
+		return
+	}
+	// Discard if packet is older than the last processed one.
+	if msg.Seq <= c.lastStateSeq {
+		c.stats.outOfOrder()
+		return
+	}
+
+	var state shared.State
+	var err error
+	if msg.Type == MessageTypeStateDelta {
+		state, err = DecodeStateDelta(msg.Data, c.snapshots.get)
+	} else {
+		state, err = DecodeState(msg.Data)
+	}
+	if err != nil {
+		fmt.Println("Error decoding state:", err)
+		return
+	}
+	c.lastStateSeq = msg.Seq
+	c.snapshots.put(msg.Seq, state)
+	// Acknowledge the snapshot so the server can use it as a delta baseline.
+	if cur.capabilities.Has(CapabilityDeltaSnapshots) {
+		ack, _ := EncodeMessage(Message{Type: MessageTypeSnapshotAck, Seq: msg.Seq})
+		cur.secure.send(ack)
+	}
+	if c.OnStateUpdate != nil {
+		// The history keeps the server's timestamps for delta baselines.
+		state.Timestamp = c.Clock.ToLocal(state.Timestamp)
+		c.OnStateUpdate(state)
+	}
+}
+
+func (c *Client) handlePong(msg Message) {
+	var sentTime, serverTime int64
+	reader := bytes.NewReader(msg.Data)
+	if err := binary.Read(reader, binary.BigEndian, &sentTime); err == nil {
+		now := time.Now().UnixNano()
+		c.stats.addRTT(time.Duration(now - sentTime))
+		if err := binary.Read(reader, binary.BigEndian, &serverTime); err == nil {
+			c.Clock.AddSample(sentTime, serverTime, now)
+		}
+	}
+}
+
+// handlePing answers the server's pings, with which it measures its RTT
+// to us as well.
+func (c *Client) handlePing(msg Message) {
+	pong, _ := EncodeMessage(Message{Type: MessageTypePong, Seq: msg.Seq, Data: msg.Data})
+	if cur := c.connection(); cur != nil {
+		cur.secure.send(pong)
 	}
 }
 
+func (c *Client) handleFragment(msg Message) {
+	cur := c.connection()
+	if cur == nil {
+		return
+	}
+	if whole, ok := cur.secure.reassemble(msg); ok {
+		c.handleMessage(whole)
+	}
+}
+
+func (c *Client) handleReliable(msg Message) {
+	cur := c.connection()
+	if cur == nil {
+		return
+	}
+	for _, m := range cur.reliable.Receive(msg) {
+		c.handleMessage(m)
+	}
+}
+
+func (c *Client) handleAck(msg Message) {
+	if cur := c.connection(); cur != nil {
+		cur.reliable.HandleAck(msg)
+	}
+}
+
+func (c *Client) handleDisconnect(msg Message) {
+	if c.OnDisconnect != nil {
+		c.OnDisconnect()
+	}
+}
+
+// Disconnect tells the server that this client is leaving, so it frees
+// the slot immediately instead of waiting for the timeout.
+func (c *Client) Disconnect() error {
+	cur := c.connection()
+	if cur == nil {
+		return errNotConnected
+	}
+	data, err := EncodeMessage(Message{Type: MessageTypeDisconnect})
+	if err != nil {
+		return err
+	}
+	return cur.secure.send(data)
+}
+
+// Send transmits msg to the server, using the reliable channel if its type
+// is listed in ReliableTypes.
 func (c *Client) Send(msg Message) error {
+	cur := c.connection()
+	if cur == nil {
+		return errNotConnected
+	}
+	if ReliableTypes[msg.Type] {
+		return cur.reliable.Send(msg)
+	}
 	data, err := EncodeMessage(msg)
 	if err != nil {
 		return err
 	}
-	_, err = c.Conn.Write(data)
-	return err
+	return cur.secure.send(data)
+}
+
+// handshake sends hs over conn and returns the server's reply. The
+// handshake itself is not sent reliably, so it is retransmitted until the
+// server answers, deadline passes or ctx is done. The first attempt is
+// answered with a cookie to send the handshake again with, along with the
+// proof of inviteCode that depends on it.
+func handshake(ctx context.Context, conn Conn, hs Handshake, inviteCode string, deadline time.Time) (HandshakeReply, error) {
+	buf := make([]byte, maxDatagramSize)
+	var msg Message
+	for {
+		if err := ctx.Err(); err != nil {
+			return HandshakeReply{}, err
+		}
+		encoded, err := encodeHandshakeMessage(hs)
+		if err != nil {
+			return HandshakeReply{}, err
+		}
+		if _, err = conn.Write(encoded); err != nil {
+			return HandshakeReply{}, err
+		}
+		conn.SetReadDeadline(minTime(deadline, time.Now().Add(handshakeRetryInterval)))
+		msg, err = readHandshakeReply(conn, buf)
+		if err == nil && msg.Type == MessageTypeCookie {
+			copy(hs.Cookie[:], msg.Data)
+			hs.InviteProof = inviteProof(inviteCode, hs.Nonce, hs.Cookie)
+			// A lobby sends the key to seal the invite code for along
+			// with the cookie.
+			hs.SealedInvite = nil
+			if len(msg.Data) > len(hs.Cookie) {
+				if hs.SealedInvite, err = sealInvite(inviteCode, msg.Data[len(hs.Cookie):]); err != nil {
+					return HandshakeReply{}, fmt.Errorf("failed to seal invite code: %v", err)
+				}
+			}
+			continue
+		}
+		if err == nil {
+			break
+		}
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() || time.Now().After(deadline) {
+			return HandshakeReply{}, fmt.Errorf("failed to receive handshake response: %v", err)
+		}
+	}
+	// Reset deadline.
+	conn.SetReadDeadline(time.Time{})
+	if msg.Type == MessageTypeError {
+		perr, err := DecodeError(msg.Data)
+		if err != nil {
+			return HandshakeReply{}, fmt.Errorf("handshake error: %s", string(msg.Data))
+		}
+		return HandshakeReply{}, fmt.Errorf("handshake error: %w", perr)
+	}
+	reply, err := DecodeHandshakeReply(msg.Data)
+	if err != nil {
+		return HandshakeReply{}, fmt.Errorf("failed to decode handshake reply: %v", err)
+	}
+	return reply, nil
+}
+
+func encodeHandshakeMessage(hs Handshake) ([]byte, error) {
+	payload, err := EncodeHandshake(hs)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeMessage(Message{Type: MessageTypeHandshake, Data: payload})
+}
+
+// readHandshakeReply reads from conn until a handshake reply, cookie or
+// error arrives, skipping anything else the server sends first, such as state
+// broadcasts that follow a reply which got lost.
+func readHandshakeReply(conn Conn, buf []byte) (Message, error) {
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return Message{}, err
+		}
+		msg, err := DecodeMessage(buf[:n])
+		if err == nil && (msg.Type == MessageTypeHandshakeSuccess || msg.Type == MessageTypeCookie || msg.Type == MessageTypeError) {
+			return msg, nil
+		}
+	}
+}
+
+// wait sleeps for d and reports whether done stayed open meanwhile.
+func wait(done chan struct{}, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// isClosed reports whether done has been closed.
+func isClosed(done chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
 }
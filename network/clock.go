@@ -0,0 +1,121 @@
+package network
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	// clockSamples is how many ping/pong exchanges the clock estimate is
+	// based on; with one ping per second they span about 16 seconds.
+	clockSamples = 16
+	// maxClockDrift bounds the estimated drift, in nanoseconds per
+	// nanosecond. Real clocks drift by well under 100 ppm; anything beyond
+	// this is noise from a short window.
+	maxClockDrift = 500e-6
+)
+
+// clockSample is the outcome of one ping/pong exchange.
+type clockSample struct {
+	local  int64 // local time halfway between ping and pong
+	offset int64 // server clock minus local clock at that time
+	rtt    int64
+}
+
+// ClockSync estimates the offset and drift of the server's clock relative
+// to the local one, NTP-style, from the timestamps of ping/pong exchanges.
+// Times are Unix nanoseconds.
+type ClockSync struct {
+	mu      sync.Mutex
+	samples []clockSample
+	// The estimate: the offset is base at local time ref and changes by
+	// drift per nanosecond.
+	ref, base int64
+	drift     float64
+}
+
+// AddSample records a ping sent at local time sent, stamped by the server
+// at serverTime and answered at local time received.
+func (c *ClockSync) AddSample(sent, serverTime, received int64) {
+	if received < sent {
+		return
+	}
+	mid := sent + (received-sent)/2
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.samples = append(c.samples, clockSample{local: mid, offset: serverTime - mid, rtt: received - sent})
+	if len(c.samples) > clockSamples {
+		c.samples = c.samples[1:]
+	}
+	c.estimateLocked()
+}
+
+// estimateLocked fits the offset over the faster half of the samples,
+// whose timestamps were delayed the least by queueing.
+func (c *ClockSync) estimateLocked() {
+	best := append([]clockSample(nil), c.samples...)
+	sort.Slice(best, func(i, j int) bool { return best[i].rtt < best[j].rtt })
+	best = best[:(len(best)+1)/2]
+	if len(best) < 4 {
+		c.ref, c.base, c.drift = best[0].local, best[0].offset, 0
+		return
+	}
+	var meanX, meanY float64
+	for _, s := range best {
+		meanX += float64(s.local)
+		meanY += float64(s.offset)
+	}
+	meanX /= float64(len(best))
+	meanY /= float64(len(best))
+	var cov, variance float64
+	for _, s := range best {
+		dx := float64(s.local) - meanX
+		cov += dx * (float64(s.offset) - meanY)
+		variance += dx * dx
+	}
+	c.ref, c.base, c.drift = int64(meanX), int64(meanY), 0
+	if variance > 0 {
+		c.drift = max(-maxClockDrift, min(maxClockDrift, cov/variance))
+	}
+}
+
+// Synced reports whether at least one exchange has been recorded.
+func (c *ClockSync) Synced() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.samples) > 0
+}
+
+// Offset returns the estimated server clock minus local clock at local
+// time t.
+func (c *ClockSync) Offset(t int64) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.offsetLocked(t)
+}
+
+func (c *ClockSync) offsetLocked(t int64) int64 {
+	if len(c.samples) == 0 {
+		return 0
+	}
+	return c.base + int64(c.drift*float64(t-c.ref))
+}
+
+// Drift returns the estimated rate at which the offset changes, in
+// nanoseconds per nanosecond.
+func (c *ClockSync) Drift() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.drift
+}
+
+// ToLocal maps a server timestamp to the local clock. Before the first
+// exchange it is returned unchanged.
+func (c *ClockSync) ToLocal(serverTime int64) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// The offset changes so slowly that base is close enough to it to
+	// find the local time to evaluate it at.
+	approx := serverTime - c.base
+	return serverTime - c.offsetLocked(approx)
+}
@@ -0,0 +1,148 @@
+package network
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestClockSync(t *testing.T) {
+	const start = int64(1_700_000_000 * time.Second)
+	type sample struct{ sent, serverTime, received int64 }
+	// exchange is a ping sent at local time sent, taking up and down to
+	// reach the server and come back, with the server's clock at offset
+	// from the local one when it stamps the pong.
+	exchange := func(sent int64, offset, up, down time.Duration) sample {
+		stamped := sent + int64(up)
+		return sample{sent, stamped + int64(offset), stamped + int64(down)}
+	}
+	// pings returns one exchange a second, offset(i) and rtt(i) apart.
+	pings := func(n int, offset func(i int) time.Duration, rtt func(i int) (up, down time.Duration)) []sample {
+		var samples []sample
+		for i := range n {
+			up, down := rtt(i)
+			samples = append(samples, exchange(start+int64(i)*int64(time.Second), offset(i), up, down))
+		}
+		return samples
+	}
+	constant := func(d time.Duration) func(int) time.Duration { return func(int) time.Duration { return d } }
+	symmetric := func(int) (time.Duration, time.Duration) { return 10 * time.Millisecond, 10 * time.Millisecond }
+
+	tests := []struct {
+		name       string
+		samples    []sample
+		synced     bool
+		at         int64         // local time to check the offset at
+		wantOffset time.Duration // at local time at
+		wantDrift  float64
+		// tolerances of the offset and the drift
+		offsetTol time.Duration
+		driftTol  float64
+	}{
+		{
+			name: "before sync",
+			at:   start,
+		},
+		{
+			name:    "answer before the ping",
+			samples: []sample{{start, start, start - 1}},
+			at:      start,
+		},
+		{
+			name:       "single exchange",
+			samples:    pings(1, constant(3*time.Second), symmetric),
+			synced:     true,
+			at:         start + int64(time.Minute),
+			wantOffset: 3 * time.Second,
+		},
+		{
+			name:       "constant offset",
+			samples:    pings(clockSamples, constant(-2*time.Second), symmetric),
+			synced:     true,
+			at:         start + int64(time.Minute),
+			wantOffset: -2 * time.Second,
+			offsetTol:  time.Microsecond,
+			driftTol:   1e-9,
+		},
+		{
+			// The server's clock runs 100 ppm fast, and the offset keeps
+			// growing after the last sample.
+			name:       "linear drift",
+			samples:    pings(clockSamples, func(i int) time.Duration { return time.Second + time.Duration(i)*100*time.Microsecond }, symmetric),
+			synced:     true,
+			at:         start + int64(clockSamples-1+10)*int64(time.Second),
+			wantOffset: time.Second + (clockSamples-1+10)*100*time.Microsecond,
+			wantDrift:  100e-6,
+			offsetTol:  10 * time.Microsecond,
+			driftTol:   1e-7,
+		},
+		{
+			// Every third pong was held up for half a second on its way
+			// back, which would skew the offset by a quarter second.
+			name: "outlier round trips",
+			samples: pings(clockSamples, constant(time.Second), func(i int) (time.Duration, time.Duration) {
+				if i%3 == 0 {
+					return 10 * time.Millisecond, 500 * time.Millisecond
+				}
+				return 10 * time.Millisecond, 10 * time.Millisecond
+			}),
+			synced:     true,
+			at:         start + int64(clockSamples)*int64(time.Second),
+			wantOffset: time.Second,
+			offsetTol:  time.Microsecond,
+			driftTol:   1e-9,
+		},
+		{
+			// A drift of 1% can only be noise and is clamped, so the
+			// offset is off by up to the rest of the drift.
+			name:       "drift clamp",
+			samples:    pings(clockSamples, func(i int) time.Duration { return time.Duration(i) * 10 * time.Millisecond }, symmetric),
+			synced:     true,
+			at:         start + int64(clockSamples/2)*int64(time.Second),
+			wantOffset: clockSamples / 2 * 10 * time.Millisecond,
+			wantDrift:  maxClockDrift,
+			offsetTol:  clockSamples / 2 * 10 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c ClockSync
+			for _, s := range tt.samples {
+				c.AddSample(s.sent, s.serverTime, s.received)
+			}
+			if c.Synced() != tt.synced {
+				t.Fatalf("Synced = %v, want %v", c.Synced(), tt.synced)
+			}
+			offset := c.Offset(tt.at)
+			if d := time.Duration(offset) - tt.wantOffset; d.Abs() > tt.offsetTol {
+				t.Errorf("Offset = %v, want %v", time.Duration(offset), tt.wantOffset)
+			}
+			if d := c.Drift() - tt.wantDrift; math.Abs(d) > tt.driftTol {
+				t.Errorf("Drift = %g, want %g", c.Drift(), tt.wantDrift)
+			}
+			// ToLocal maps a server time back to the local time it
+			// happened at, up to the drift over the offset, and leaves it
+			// alone before the first exchange.
+			tol := time.Microsecond + time.Duration(math.Abs(c.Drift()*float64(offset)))
+			if got := c.ToLocal(tt.at + offset); time.Duration(got-tt.at).Abs() > tol {
+				t.Errorf("ToLocal(%d) = %d, want %d", tt.at+offset, got, tt.at)
+			}
+		})
+	}
+}
+
+func TestClockSyncWindow(t *testing.T) {
+	// The estimate follows a change of offset once the old samples have
+	// left the window.
+	var c ClockSync
+	sent := int64(0)
+	for _, offset := range []time.Duration{time.Second, 2 * time.Second} {
+		for range clockSamples {
+			c.AddSample(sent, sent+int64(5*time.Millisecond+offset), sent+int64(10*time.Millisecond))
+			sent += int64(time.Second)
+		}
+	}
+	if got := time.Duration(c.Offset(sent)); (got - 2*time.Second).Abs() > time.Microsecond {
+		t.Errorf("Offset = %v, want 2s", got)
+	}
+}
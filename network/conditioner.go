@@ -0,0 +1,138 @@
+package network
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// reorderHold is how much longer than the others a reordered datagram is
+// held back.
+const reorderHold = 30 * time.Millisecond
+
+// Conditions describe a bad network to simulate. They apply to the
+// datagrams a conn sends as well as those it receives, so conditioning one
+// side of a connection affects both directions.
+type Conditions struct {
+	// Latency is added to every datagram.
+	Latency time.Duration
+	// Jitter is the most random delay added on top of Latency.
+	Jitter time.Duration
+	// Loss is the probability that a datagram is dropped.
+	Loss float64
+	// Duplicate is the probability that a datagram is delivered twice.
+	Duplicate float64
+	// Reorder is the probability that a datagram is held back behind the
+	// ones that follow it.
+	Reorder float64
+	// Seed seeds the random choices of each conn, so that a run with the
+	// same traffic makes the same choices. Zero picks a random seed.
+	Seed int64
+}
+
+// Active reports whether the conditions change the traffic at all.
+func (c Conditions) Active() bool {
+	c.Seed = 0
+	return c != Conditions{}
+}
+
+// conditionedConn wraps a conn to delay, drop, duplicate and reorder its
+// datagrams according to Conditions.
+type conditionedConn struct {
+	// queue holds received datagrams once their delay is over.
+	*queue
+	conn       Conn
+	conditions Conditions
+
+	// mu guards rand, which makes the random choices for the senders and
+	// the receiving goroutine alike.
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// Condition wraps conn so that its traffic suffers the given conditions.
+// Without any active conditions conn is returned as is.
+func Condition(conn Conn, conditions Conditions) Conn {
+	if !conditions.Active() {
+		return conn
+	}
+	seed := conditions.Seed
+	if seed == 0 {
+		seed = rand.Int63()
+	}
+	c := &conditionedConn{queue: newQueue(), conn: conn, conditions: conditions, rand: rand.New(rand.NewSource(seed))}
+	go c.receive()
+	return c
+}
+
+// schedule runs deliver for one datagram: not at all if it is lost,
+// otherwise once or twice, each time after its own delay.
+func (c *conditionedConn) schedule(deliver func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rand.Float64() < c.conditions.Loss {
+		return
+	}
+	copies := 1
+	if c.rand.Float64() < c.conditions.Duplicate {
+		copies = 2
+	}
+	for range copies {
+		time.AfterFunc(c.delayLocked(), deliver)
+	}
+}
+
+// delayLocked picks the delay of one datagram.
+func (c *conditionedConn) delayLocked() time.Duration {
+	d := c.conditions.Latency
+	if c.conditions.Jitter > 0 {
+		d += time.Duration(c.rand.Int63n(int64(c.conditions.Jitter)))
+	}
+	if c.rand.Float64() < c.conditions.Reorder {
+		d += reorderHold
+	}
+	return d
+}
+
+// receive passes received datagrams on after their delay. Errors are
+// passed on right away, and only a closed conn ends the loop; other errors,
+// such as an ICMP port unreachable reported for an earlier datagram, are
+// returned by a single read.
+func (c *conditionedConn) receive() {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := c.conn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			c.fail(err)
+			return
+		}
+		if err != nil {
+			c.push(datagram{err: err})
+			continue
+		}
+		d := datagram{data: append([]byte(nil), buf[:n]...), addr: addr}
+		c.schedule(func() { c.push(d) })
+	}
+}
+
+func (c *conditionedConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	data := append([]byte(nil), b...)
+	c.schedule(func() { c.conn.WriteToUDP(data, addr) })
+	return len(b), nil
+}
+
+func (c *conditionedConn) Write(b []byte) (int, error) {
+	data := append([]byte(nil), b...)
+	c.schedule(func() { c.conn.Write(data) })
+	return len(b), nil
+}
+
+func (c *conditionedConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *conditionedConn) Close() error {
+	return c.conn.Close()
+}
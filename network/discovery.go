@@ -0,0 +1,213 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DiscoveryPort is the UDP port that LAN game announcements are broadcast to.
+const DiscoveryPort = 9999
+
+const (
+	// announceInterval is how often a server announces itself on the LAN.
+	announceInterval = time.Second
+	// discoveredGameTTL is how long a Browser lists a game after its last
+	// announcement.
+	discoveredGameTTL = 3 * announceInterval
+)
+
+// Announcement is the payload of a MessageTypeAnnounce message.
+type Announcement struct {
+	// Name is the host's name to show in the join menu.
+	Name string
+	// Port is the UDP port the game server listens on.
+	Port uint16
+	// OpenSlots is the number of paddles still free.
+	OpenSlots uint8
+	// InviteRequired tells whether joining needs an invite code.
+	InviteRequired bool
+}
+
+// EncodeAnnouncement produces the binary representation of an announcement:
+// the length-prefixed name, 2 bytes for the port, 1 byte for the open slots
+// and 1 byte that is 1 if an invite code is required.
+func EncodeAnnouncement(a Announcement) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := writeString(buf, a.Name); err != nil {
+		return nil, err
+	}
+	binary.Write(buf, binary.BigEndian, a.Port)
+	buf.WriteByte(a.OpenSlots)
+	if a.InviteRequired {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeAnnouncement converts a binary announcement back into an Announcement.
+func DecodeAnnouncement(data []byte) (Announcement, error) {
+	var a Announcement
+	buf := bytes.NewReader(data)
+	var err error
+	if a.Name, err = readString(buf); err != nil {
+		return a, err
+	}
+	if err := binary.Read(buf, binary.BigEndian, &a.Port); err != nil {
+		return a, err
+	}
+	if a.OpenSlots, err = buf.ReadByte(); err != nil {
+		return a, err
+	}
+	inviteRequired, err := buf.ReadByte()
+	if err != nil {
+		return a, err
+	}
+	a.InviteRequired = inviteRequired != 0
+	return a, nil
+}
+
+// announceLoop broadcasts the server on the LAN under Server.AnnounceName.
+// Only servers listening on UDP are announced, as the clients that find them
+// with a Browser join over UDP.
+func (s *Server) announceLoop() {
+	local, ok := s.conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		fmt.Println("Not announcing on the LAN: the server doesn't listen on UDP")
+		return
+	}
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4bcast, Port: DiscoveryPort})
+	if err != nil {
+		fmt.Println("Error opening announcement socket:", err)
+		return
+	}
+	defer conn.Close()
+	for {
+		data, err := EncodeAnnouncement(s.announcement(local.Port))
+		if err == nil {
+			encoded, _ := EncodeMessage(Message{Type: MessageTypeAnnounce, Data: data})
+			_, err = conn.Write(encoded)
+		}
+		if err != nil {
+			fmt.Println("Error announcing game:", err)
+		}
+		if !wait(s.done, announceInterval) {
+			return
+		}
+	}
+}
+
+// announcement describes the server listening on port as it currently is.
+func (s *Server) announcement(port int) Announcement {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+	open := playerSlots - len(s.reservations)
+	for _, k := range s.slots {
+		if k != "" {
+			open--
+		}
+	}
+	return Announcement{
+		Name:           s.AnnounceName,
+		Port:           uint16(port),
+		OpenSlots:      uint8(max(open, 0)),
+		InviteRequired: s.ExpectedInviteCode != "",
+	}
+}
+
+// DiscoveredGame is a game found on the LAN by a Browser.
+type DiscoveredGame struct {
+	Announcement
+	// Address is where to connect to: the announcing host and its Port.
+	Address string
+
+	lastSeen time.Time
+}
+
+// Browser collects the games announced on the LAN.
+type Browser struct {
+	Address string
+
+	conn  *net.UDPConn
+	mu    sync.Mutex
+	games map[string]DiscoveredGame
+}
+
+func NewBrowser() *Browser {
+	return &Browser{
+		Address: fmt.Sprintf(":%d", DiscoveryPort),
+		games:   make(map[string]DiscoveredGame),
+	}
+}
+
+// Start begins listening for announcements in the background.
+func (b *Browser) Start() error {
+	udpAddr, err := net.ResolveUDPAddr("udp4", b.Address)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp4", udpAddr)
+	if err != nil {
+		return err
+	}
+	b.conn = conn
+	go b.listen()
+	return nil
+}
+
+// Close stops listening for announcements.
+func (b *Browser) Close() error {
+	return b.conn.Close()
+}
+
+func (b *Browser) listen() {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := b.conn.ReadFromUDP(buf)
+		if err != nil {
+			// The socket only fails once it's closed.
+			return
+		}
+		msg, err := DecodeMessage(buf[:n])
+		if err != nil || msg.Type != MessageTypeAnnounce {
+			continue
+		}
+		a, err := DecodeAnnouncement(msg.Data)
+		if err != nil {
+			fmt.Println("Error decoding announcement:", err)
+			continue
+		}
+		address := net.JoinHostPort(addr.IP.String(), strconv.Itoa(int(a.Port)))
+		b.mu.Lock()
+		b.games[address] = DiscoveredGame{Announcement: a, Address: address, lastSeen: time.Now()}
+		b.mu.Unlock()
+	}
+}
+
+// Games returns the games announced recently, sorted by name.
+func (b *Browser) Games() []DiscoveredGame {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	games := make([]DiscoveredGame, 0, len(b.games))
+	for address, g := range b.games {
+		if time.Since(g.lastSeen) > discoveredGameTTL {
+			delete(b.games, address)
+			continue
+		}
+		games = append(games, g)
+	}
+	sort.Slice(games, func(i, j int) bool {
+		if games[i].Name != games[j].Name {
+			return games[i].Name < games[j].Name
+		}
+		return games[i].Address < games[j].Address
+	})
+	return games
+}
@@ -0,0 +1,129 @@
+package network
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMTU is the largest datagram sent unless configured otherwise.
+	// It stays below the path MTU of practically every route, so datagrams
+	// are never fragmented by IP, whose fragments are often dropped.
+	DefaultMTU = 1200
+	// minMTU is the smallest MTU honoured; IPv4 hosts must accept datagrams
+	// of this size.
+	minMTU = 576
+	// maxDatagramSize is the size of the receive buffers, large enough for
+	// any UDP datagram so that none is ever truncated.
+	maxDatagramSize = 65535
+	// fragmentHeaderSize is the size of the index and count preceding each
+	// fragment's chunk.
+	fragmentHeaderSize = 4
+	// fragmentTimeout is how long the fragments of an incomplete message
+	// are kept.
+	fragmentTimeout = 5 * time.Second
+	// maxFragmentSets bounds the incomplete messages kept per peer; the
+	// oldest is dropped to make room for a new one.
+	maxFragmentSets = 16
+)
+
+// fragment splits an encoded message into MessageTypeFragment messages
+// whose encodings are at most size bytes. The fragments carry a hash of
+// the message as their Seq, so that the fragments of a retransmission, as
+// the reliable channel makes them, fill the gaps of the earlier attempts;
+// the index and count of their chunk precede it.
+func fragment(inner []byte, size int) [][]byte {
+	h := fnv.New32a()
+	h.Write(inner)
+	id := h.Sum32()
+	chunk := size - messageHeaderSize - fragmentHeaderSize
+	count := (len(inner) + chunk - 1) / chunk
+	fragments := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		part := inner[i*chunk : min((i+1)*chunk, len(inner))]
+		data := binary.BigEndian.AppendUint16(nil, uint16(i))
+		data = binary.BigEndian.AppendUint16(data, uint16(count))
+		encoded, _ := EncodeMessage(Message{Type: MessageTypeFragment, Seq: id, Data: append(data, part...)})
+		fragments = append(fragments, encoded)
+	}
+	return fragments
+}
+
+// fragmentSet collects the fragments of one message.
+type fragmentSet struct {
+	parts    [][]byte
+	received int
+	size     int
+	started  time.Time
+}
+
+// reassembler puts fragmented messages from one peer back together.
+// Incomplete messages are dropped once they are older than fragmentTimeout,
+// which is checked whenever another fragment arrives.
+type reassembler struct {
+	mu   sync.Mutex
+	sets map[uint32]*fragmentSet
+}
+
+// add records a MessageTypeFragment message and returns the message it
+// completes, if any. Malformed fragments are ignored.
+func (r *reassembler) add(msg Message, now time.Time) (Message, bool) {
+	if len(msg.Data) < fragmentHeaderSize {
+		return Message{}, false
+	}
+	index := int(binary.BigEndian.Uint16(msg.Data))
+	count := int(binary.BigEndian.Uint16(msg.Data[2:]))
+	part := msg.Data[fragmentHeaderSize:]
+	if count < 2 || index >= count {
+		return Message{}, false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sets == nil {
+		r.sets = make(map[uint32]*fragmentSet)
+	}
+	var oldest *fragmentSet
+	var oldestID uint32
+	for id, set := range r.sets {
+		if now.Sub(set.started) > fragmentTimeout {
+			delete(r.sets, id)
+		} else if oldest == nil || set.started.Before(oldest.started) {
+			oldest, oldestID = set, id
+		}
+	}
+	set, ok := r.sets[msg.Seq]
+	if !ok {
+		if len(r.sets) >= maxFragmentSets {
+			delete(r.sets, oldestID)
+		}
+		set = &fragmentSet{parts: make([][]byte, count), started: now}
+		r.sets[msg.Seq] = set
+	}
+	if len(set.parts) != count || set.parts[index] != nil {
+		return Message{}, false
+	}
+	// No message encodes to more than a header and a uint16 of payload.
+	if set.size+len(part) > messageHeaderSize+0xFFFF {
+		delete(r.sets, msg.Seq)
+		return Message{}, false
+	}
+	set.parts[index] = part
+	set.received++
+	set.size += len(part)
+	if set.received < count {
+		return Message{}, false
+	}
+	delete(r.sets, msg.Seq)
+	inner := make([]byte, 0, set.size)
+	for _, p := range set.parts {
+		inner = append(inner, p...)
+	}
+	whole, err := DecodeMessage(inner)
+	if err != nil || whole.Type == MessageTypeFragment {
+		return Message{}, false
+	}
+	return whole, true
+}
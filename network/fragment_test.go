@@ -0,0 +1,135 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// fragmentsOf fragments a chat message of n bytes into datagrams of at
+// most size bytes.
+func fragmentsOf(t *testing.T, n, size int) (Message, []Message) {
+	t.Helper()
+	msg := Message{Type: MessageTypeChat, Seq: 3, Data: bytes.Repeat([]byte("pong"), n/4)}
+	inner, err := EncodeMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fragments []Message
+	for _, data := range fragment(inner, size) {
+		if len(data) > size {
+			t.Errorf("fragment is %d bytes, want at most %d", len(data), size)
+		}
+		f, err := DecodeMessage(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fragments = append(fragments, f)
+	}
+	return msg, fragments
+}
+
+func TestFragmentOutOfOrder(t *testing.T) {
+	msg, fragments := fragmentsOf(t, 4000, DefaultMTU)
+	if len(fragments) != 4 {
+		t.Fatalf("got %d fragments, want 4", len(fragments))
+	}
+	var r reassembler
+	now := time.Now()
+	for _, i := range []int{3, 1, 1, 0} {
+		if _, ok := r.add(fragments[i], now); ok {
+			t.Fatalf("message complete after fragment %d", i)
+		}
+	}
+	whole, ok := r.add(fragments[2], now)
+	if !ok {
+		t.Fatal("message incomplete after all fragments")
+	}
+	if whole.Type != msg.Type || whole.Seq != msg.Seq || !bytes.Equal(whole.Data, msg.Data) {
+		t.Errorf("reassembled %v seq %d with %d bytes, want %v seq %d with %d bytes",
+			whole.Type, whole.Seq, len(whole.Data), msg.Type, msg.Seq, len(msg.Data))
+	}
+	if len(r.sets) != 0 {
+		t.Errorf("%d fragment sets kept after completion, want 0", len(r.sets))
+	}
+}
+
+func TestFragmentMissingTimesOut(t *testing.T) {
+	_, fragments := fragmentsOf(t, 2000, DefaultMTU)
+	var r reassembler
+	now := time.Now()
+	for _, f := range fragments[1:] {
+		r.add(f, now)
+	}
+	// The missing fragment arrives too late to complete the message.
+	if _, ok := r.add(fragments[0], now.Add(fragmentTimeout+time.Millisecond)); ok {
+		t.Error("message completed by a fragment arriving after the timeout")
+	}
+	if len(r.sets) != 1 {
+		t.Errorf("%d fragment sets kept, want only the late fragment's", len(r.sets))
+	}
+}
+
+func TestFragmentOversizedTotal(t *testing.T) {
+	// Three fragments of 30000 bytes add up to more than any message.
+	part := make([]byte, fragmentHeaderSize+30000)
+	var r reassembler
+	now := time.Now()
+	for i := range 3 {
+		binary.BigEndian.PutUint16(part, uint16(i))
+		binary.BigEndian.PutUint16(part[2:], 3)
+		if _, ok := r.add(Message{Type: MessageTypeFragment, Seq: 9, Data: part}, now); ok {
+			t.Fatalf("oversized message completed by fragment %d", i)
+		}
+	}
+	if len(r.sets) != 0 {
+		t.Errorf("%d fragment sets kept, want the oversized one dropped", len(r.sets))
+	}
+}
+
+func TestFragmentMalformed(t *testing.T) {
+	header := func(index, count uint16) []byte {
+		data := binary.BigEndian.AppendUint16(nil, index)
+		return append(binary.BigEndian.AppendUint16(data, count), 'x')
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"short", []byte{0, 1}},
+		{"single fragment", header(0, 1)},
+		{"index beyond count", header(2, 2)},
+	}
+	var r reassembler
+	for _, tt := range tests {
+		if _, ok := r.add(Message{Type: MessageTypeFragment, Seq: 1, Data: tt.data}, time.Now()); ok {
+			t.Errorf("%s: fragment completed a message", tt.name)
+		}
+	}
+	if len(r.sets) != 0 {
+		t.Errorf("%d fragment sets kept for malformed fragments, want 0", len(r.sets))
+	}
+
+	// A fragment claiming another count than the rest of its set is ignored.
+	r.add(Message{Type: MessageTypeFragment, Seq: 2, Data: header(0, 2)}, time.Now())
+	if _, ok := r.add(Message{Type: MessageTypeFragment, Seq: 2, Data: header(1, 3)}, time.Now()); ok {
+		t.Error("fragment with a mismatched count completed a message")
+	}
+}
+
+func TestFragmentSetLimit(t *testing.T) {
+	var r reassembler
+	now := time.Now()
+	for i := range maxFragmentSets + 4 {
+		data := binary.BigEndian.AppendUint16(nil, 0)
+		data = binary.BigEndian.AppendUint16(data, 2)
+		r.add(Message{Type: MessageTypeFragment, Seq: uint32(i), Data: data}, now.Add(time.Duration(i)))
+	}
+	if len(r.sets) != maxFragmentSets {
+		t.Errorf("%d fragment sets kept, want %d", len(r.sets), maxFragmentSets)
+	}
+	if _, ok := r.sets[0]; ok {
+		t.Error("oldest fragment set kept past the limit")
+	}
+}
@@ -0,0 +1,282 @@
+package network
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// sourceRate and sourceBurst limit the datagrams accepted from one
+	// source IP. A playing client sends roughly 200 per second.
+	sourceRate  = 500
+	sourceBurst = 1000
+	// handshakeRate and handshakeBurst limit the handshakes and rendezvous
+	// registrations accepted from one source IP, cookie round trips and
+	// retransmissions included.
+	handshakeRate  = 5
+	handshakeBurst = 10
+	// cookieLifetime is how long a handshake cookie stays valid, give or
+	// take one more period.
+	cookieLifetime = 10 * time.Second
+	// maxInviteFailures wrong invite codes within inviteFailureWindow lock
+	// a source IP out of handshakes for lockoutDuration.
+	maxInviteFailures   = 5
+	inviteFailureWindow = time.Minute
+	lockoutDuration     = time.Minute
+	// sourceIdleTimeout is how long per-source state outlives the source's
+	// last datagram.
+	sourceIdleTimeout = 2 * time.Minute
+	// maxSources bounds the source IPs the guard remembers, which spoofed
+	// datagrams could otherwise grow without limit. While it is reached,
+	// idle sources are looked for at most every fullSweepInterval.
+	maxSources        = 10000
+	fullSweepInterval = time.Second
+)
+
+// Cookie is the handshake cookie a server hands out in a MessageTypeCookie
+// message. It proves that the client can receive at its source address, so
+// the server doesn't do any work or send anything larger for spoofed
+// handshakes.
+type Cookie [16]byte
+
+// RejectStats counts the traffic a server dropped or refused.
+type RejectStats struct {
+	// RateLimited counts datagrams over a source's rate limit.
+	RateLimited uint64
+	// CookieChallenges counts handshakes answered with a cookie instead of
+	// being processed, because their cookie was missing, stale or forged.
+	CookieChallenges uint64
+	// InvalidInvites counts handshakes with a wrong invite code.
+	InvalidInvites uint64
+	// LockedOut counts handshakes refused because their source is locked out.
+	LockedOut uint64
+	// Unauthenticated counts sealed datagrams that failed to open.
+	Unauthenticated uint64
+	// Banned counts datagrams from banned sources.
+	Banned uint64
+}
+
+// guard protects a server against floods and invite code guessing. It is
+// shared by every room of a lobby.
+type guard struct {
+	secret [32]byte
+
+	mu      sync.Mutex
+	sources map[string]*sourceState
+	// overflow is the state shared by every source not remembered while
+	// sources is full, so that they are rate limited together.
+	overflow  sourceState
+	banned    map[string]bool
+	lastSweep time.Time
+	stats     RejectStats
+}
+
+// sourceState is what the guard remembers about one source IP.
+type sourceState struct {
+	datagrams, handshakes tokenBucket
+	failures              int
+	firstFailure          time.Time
+	lockedUntil           time.Time
+	lastSeen              time.Time
+	// lastLookup is the last unknown invite looked up at a rendezvous
+	// server.
+	lastLookup InviteID
+}
+
+// tokenBucket refills at a fixed rate up to its burst size.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) take(now time.Time, rate, burst float64) bool {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func newGuard() *guard {
+	g := &guard{sources: make(map[string]*sourceState), banned: make(map[string]bool)}
+	rand.Read(g.secret[:])
+	return g
+}
+
+// sourceLocked returns the state of addr's IP, forgetting idle sources
+// now and then. Once maxSources are remembered, new sources share the
+// overflow state until idle ones have been forgotten.
+func (g *guard) sourceLocked(addr *net.UDPAddr, now time.Time) *sourceState {
+	full := len(g.sources) >= maxSources
+	if since := now.Sub(g.lastSweep); since > sourceIdleTimeout || full && since > fullSweepInterval {
+		g.sweepLocked(now)
+	}
+	ip := addr.IP.String()
+	src, ok := g.sources[ip]
+	if !ok && len(g.sources) >= maxSources {
+		src = &g.overflow
+	} else if !ok {
+		src = &sourceState{}
+		g.sources[ip] = src
+	}
+	src.lastSeen = now
+	return src
+}
+
+// sweepLocked forgets the sources idle for sourceIdleTimeout that aren't
+// locked out.
+func (g *guard) sweepLocked(now time.Time) {
+	for ip, src := range g.sources {
+		if now.Sub(src.lastSeen) > sourceIdleTimeout && now.After(src.lockedUntil) {
+			delete(g.sources, ip)
+		}
+	}
+	g.lastSweep = now
+}
+
+// admit reports whether a datagram of type t from addr is within its
+// source's rate limits and the source isn't banned.
+func (g *guard) admit(addr *net.UDPAddr, t MessageType) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.banned[addr.IP.String()] {
+		g.stats.Banned++
+		return false
+	}
+	now := time.Now()
+	src := g.sourceLocked(addr, now)
+	ok := src.datagrams.take(now, sourceRate, sourceBurst)
+	if ok && (t == MessageTypeHandshake || t == MessageTypeRegister) {
+		ok = src.handshakes.take(now, handshakeRate, handshakeBurst)
+	}
+	if !ok {
+		g.stats.RateLimited++
+	}
+	return ok
+}
+
+// cookie returns the cookie for addr in the given lifetime period.
+func (g *guard) cookie(addr *net.UDPAddr, period int64) Cookie {
+	mac := hmac.New(sha256.New, g.secret[:])
+	mac.Write(addr.IP.To16())
+	binary.Write(mac, binary.BigEndian, uint16(addr.Port))
+	binary.Write(mac, binary.BigEndian, period)
+	var c Cookie
+	copy(c[:], mac.Sum(nil))
+	return c
+}
+
+// currentCookie returns the cookie to hand out to addr.
+func (g *guard) currentCookie(addr *net.UDPAddr) Cookie {
+	return g.cookie(addr, time.Now().UnixNano()/int64(cookieLifetime))
+}
+
+// checkCookie reports whether c was handed out to addr recently. If not,
+// the handshake counts as challenged.
+func (g *guard) checkCookie(addr *net.UDPAddr, c Cookie) bool {
+	period := time.Now().UnixNano() / int64(cookieLifetime)
+	for _, p := range []int64{period, period - 1} {
+		if want := g.cookie(addr, p); hmac.Equal(c[:], want[:]) {
+			return true
+		}
+	}
+	g.count(func(s *RejectStats) { s.CookieChallenges++ })
+	return false
+}
+
+// lockedOut reports whether addr's source may not attempt handshakes now.
+func (g *guard) lockedOut(addr *net.UDPAddr) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	if now.Before(g.sourceLocked(addr, now).lockedUntil) {
+		g.stats.LockedOut++
+		return true
+	}
+	return false
+}
+
+// inviteFailed records a wrong invite code from addr's source and locks
+// the source out once it has guessed wrong too often.
+func (g *guard) inviteFailed(addr *net.UDPAddr) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stats.InvalidInvites++
+	now := time.Now()
+	src := g.sourceLocked(addr, now)
+	if now.Sub(src.firstFailure) > inviteFailureWindow {
+		src.failures, src.firstFailure = 0, now
+	}
+	src.failures++
+	if src.failures >= maxInviteFailures {
+		src.lockedUntil = now.Add(lockoutDuration)
+		src.failures = 0
+	}
+}
+
+// lookupFailed records a rendezvous lookup of an unknown invite from addr
+// like a wrong invite code. Joiners repeat their lookup until the host has
+// registered, so a source looking up the same invite again doesn't count.
+func (g *guard) lookupFailed(addr *net.UDPAddr, invite InviteID) {
+	g.mu.Lock()
+	src := g.sourceLocked(addr, time.Now())
+	repeated := src.lastLookup == invite
+	src.lastLookup = invite
+	g.mu.Unlock()
+	if !repeated {
+		g.inviteFailed(addr)
+	}
+}
+
+// ban drops all traffic from ip from now on, or again admits it.
+func (g *guard) ban(ip net.IP, banned bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if banned {
+		g.banned[ip.String()] = true
+	} else {
+		delete(g.banned, ip.String())
+	}
+}
+
+// count updates the counters under the guard's lock.
+func (g *guard) count(update func(s *RejectStats)) {
+	g.mu.Lock()
+	update(&g.stats)
+	g.mu.Unlock()
+}
+
+// RejectStats returns the counters of traffic the server dropped or refused.
+// The rooms of a lobby share their counters.
+func (s *Server) RejectStats() RejectStats {
+	s.guard.mu.Lock()
+	defer s.guard.mu.Unlock()
+	return s.guard.stats
+}
+
+// RejectStats returns the counters of traffic the rendezvous server dropped
+// or refused.
+func (r *Rendezvous) RejectStats() RejectStats {
+	r.guard.mu.Lock()
+	defer r.guard.mu.Unlock()
+	return r.guard.stats
+}
+
+// RejectStats returns the counters of traffic the lobby and its rooms
+// dropped or refused.
+func (l *Lobby) RejectStats() RejectStats {
+	l.guard.mu.Lock()
+	defer l.guard.mu.Unlock()
+	return l.guard.stats
+}
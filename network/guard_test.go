@@ -0,0 +1,193 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// sourceAddr returns a distinct address for each i.
+func sourceAddr(i int) *net.UDPAddr {
+	return &net.UDPAddr{IP: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)), Port: 4000}
+}
+
+func TestTokenBucket(t *testing.T) {
+	var b tokenBucket
+	now := time.Now()
+	for i := range 3 {
+		if !b.take(now, 2, 3) {
+			t.Fatalf("take %d of a burst of 3 refused", i+1)
+		}
+	}
+	if b.take(now, 2, 3) {
+		t.Error("take beyond the burst allowed")
+	}
+	// Two tokens a second: one is back after half a second.
+	now = now.Add(500 * time.Millisecond)
+	if !b.take(now, 2, 3) {
+		t.Error("take after refilling one token refused")
+	}
+	if b.take(now, 2, 3) {
+		t.Error("second take after refilling one token allowed")
+	}
+	// A long pause refills no more than the burst.
+	now = now.Add(time.Hour)
+	for i := range 3 {
+		if !b.take(now, 2, 3) {
+			t.Fatalf("take %d after a long pause refused", i+1)
+		}
+	}
+	if b.take(now, 2, 3) {
+		t.Error("take beyond the burst after a long pause allowed")
+	}
+}
+
+func TestGuardCookies(t *testing.T) {
+	g, other := newGuard(), newGuard()
+	addr := sourceAddr(1)
+	period := time.Now().UnixNano() / int64(cookieLifetime)
+	tests := []struct {
+		name   string
+		cookie Cookie
+		want   bool
+	}{
+		{"current", g.currentCookie(addr), true},
+		{"previous period", g.cookie(addr, period-1), true},
+		{"stale", g.cookie(addr, period-2), false},
+		{"other port", g.currentCookie(&net.UDPAddr{IP: addr.IP, Port: addr.Port + 1}), false},
+		{"other IP", g.currentCookie(sourceAddr(2)), false},
+		{"other server", other.currentCookie(addr), false},
+		{"missing", Cookie{}, false},
+	}
+	challenged := uint64(0)
+	for _, tt := range tests {
+		if got := g.checkCookie(addr, tt.cookie); got != tt.want {
+			t.Errorf("checkCookie(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+		if !tt.want {
+			challenged++
+		}
+	}
+	if g.stats.CookieChallenges != challenged {
+		t.Errorf("CookieChallenges = %d, want %d", g.stats.CookieChallenges, challenged)
+	}
+}
+
+func TestGuardRateLimit(t *testing.T) {
+	g := newGuard()
+	a, b := sourceAddr(1), sourceAddr(2)
+	for i := range handshakeBurst {
+		if !g.admit(a, MessageTypeHandshake) {
+			t.Fatalf("handshake %d of the burst refused", i+1)
+		}
+	}
+	if g.admit(a, MessageTypeHandshake) {
+		t.Error("handshake beyond the burst admitted")
+	}
+	// Other ports of the same IP share its buckets, other IPs don't.
+	if g.admit(&net.UDPAddr{IP: a.IP, Port: a.Port + 1}, MessageTypeRegister) {
+		t.Error("registration from another port of a limited IP admitted")
+	}
+	if !g.admit(b, MessageTypeHandshake) {
+		t.Error("handshake from another IP refused")
+	}
+	// The handshakes came out of the datagram bucket as well, which has
+	// plenty left.
+	if !g.admit(a, MessageTypeSecure) {
+		t.Error("datagram of a source out of handshakes refused")
+	}
+	if st := g.stats; st.RateLimited != 2 {
+		t.Errorf("RateLimited = %d, want 2", st.RateLimited)
+	}
+}
+
+func TestGuardLockout(t *testing.T) {
+	g := newGuard()
+	a, b := sourceAddr(1), sourceAddr(2)
+	for range maxInviteFailures - 1 {
+		g.inviteFailed(a)
+	}
+	if g.lockedOut(a) {
+		t.Fatal("locked out before too many wrong invite codes")
+	}
+	g.inviteFailed(a)
+	if !g.lockedOut(a) {
+		t.Fatal("not locked out after too many wrong invite codes")
+	}
+	if g.lockedOut(b) {
+		t.Error("another source locked out")
+	}
+	if st := g.stats; st.InvalidInvites != maxInviteFailures || st.LockedOut != 1 {
+		t.Errorf("InvalidInvites = %d and LockedOut = %d, want %d and 1", st.InvalidInvites, st.LockedOut, maxInviteFailures)
+	}
+
+	// Failures further apart than the window don't add up.
+	for range maxInviteFailures {
+		g.inviteFailed(b)
+		g.sources[b.IP.String()].firstFailure = time.Now().Add(-inviteFailureWindow - time.Second)
+	}
+	if g.lockedOut(b) {
+		t.Error("locked out by wrong invite codes spread over more than the window")
+	}
+
+	// The lockout ends.
+	g.sources[a.IP.String()].lockedUntil = time.Now().Add(-time.Second)
+	if g.lockedOut(a) {
+		t.Error("still locked out after the lockout ended")
+	}
+}
+
+func TestGuardBan(t *testing.T) {
+	g := newGuard()
+	a := sourceAddr(1)
+	g.ban(a.IP, true)
+	if g.admit(a, MessageTypeSecure) {
+		t.Error("datagram from a banned IP admitted")
+	}
+	if !g.admit(sourceAddr(2), MessageTypeSecure) {
+		t.Error("datagram from another IP refused")
+	}
+	if g.stats.Banned != 1 {
+		t.Errorf("Banned = %d, want 1", g.stats.Banned)
+	}
+	g.ban(a.IP, false)
+	if !g.admit(a, MessageTypeSecure) {
+		t.Error("datagram refused after lifting the ban")
+	}
+}
+
+func TestGuardSourceCap(t *testing.T) {
+	g := newGuard()
+	locked := sourceAddr(0)
+	for range maxInviteFailures {
+		g.inviteFailed(locked)
+	}
+	// A flood from spoofed addresses.
+	for i := 1; i <= 2*maxSources; i++ {
+		g.admit(sourceAddr(i), MessageTypeHandshake)
+	}
+	if n := len(g.sources); n > maxSources {
+		t.Errorf("guard remembers %d sources, want at most %d", n, maxSources)
+	}
+	// Sources beyond the cap share one rate limit.
+	if st := g.stats; st.RateLimited == 0 {
+		t.Error("no datagram beyond the cap was rate limited")
+	}
+	// The flood can't make the guard forget a lockout.
+	if !g.lockedOut(locked) {
+		t.Error("lockout forgotten during the flood")
+	}
+
+	// Sources idle for long are forgotten to make room again.
+	for _, src := range g.sources {
+		src.lastSeen = time.Now().Add(-sourceIdleTimeout - time.Second)
+	}
+	g.lastSweep = time.Time{}
+	g.admit(sourceAddr(3*maxSources), MessageTypeSecure)
+	if _, ok := g.sources[sourceAddr(3*maxSources).IP.String()]; !ok {
+		t.Error("new source not remembered after the idle ones were forgotten")
+	}
+	if !g.lockedOut(locked) {
+		t.Error("idle locked out source forgotten")
+	}
+}
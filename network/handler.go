@@ -0,0 +1,68 @@
+package network
+
+import (
+	"net"
+	"sync"
+)
+
+// MessageTypeUser is the first message type reserved for applications;
+// the package never uses it or any type above it.
+const MessageTypeUser MessageType = 128
+
+// ServerHandler handles a message from the client at addr.
+type ServerHandler func(addr *net.UDPAddr, msg Message)
+
+// ClientHandler handles a message from the server.
+type ClientHandler func(msg Message)
+
+// handlerRegistry maps message types to their handlers.
+type handlerRegistry[H any] struct {
+	mu       sync.RWMutex
+	handlers map[MessageType]H
+}
+
+func (r *handlerRegistry[H]) set(t MessageType, h H) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.handlers == nil {
+		r.handlers = make(map[MessageType]H)
+	}
+	r.handlers[t] = h
+}
+
+func (r *handlerRegistry[H]) remove(t MessageType) {
+	r.mu.Lock()
+	delete(r.handlers, t)
+	r.mu.Unlock()
+}
+
+func (r *handlerRegistry[H]) get(t MessageType) (H, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	h, ok := r.handlers[t]
+	return h, ok
+}
+
+// Handle registers h for authenticated messages of type t from clients,
+// including those delivered over the reliable channel. It replaces any
+// earlier handler, the server's own included; a nil h removes it. Types
+// without a handler go to OnMessage.
+func (s *Server) Handle(t MessageType, h ServerHandler) {
+	if h == nil {
+		s.handlers.remove(t)
+		return
+	}
+	s.handlers.set(t, h)
+}
+
+// Handle registers h for authenticated messages of type t from the server,
+// including those delivered over the reliable channel. It replaces any
+// earlier handler, the client's own included; a nil h removes it. Types
+// without a handler go to OnMessage.
+func (c *Client) Handle(t MessageType, h ClientHandler) {
+	if h == nil {
+		c.handlers.remove(t)
+		return
+	}
+	c.handlers.set(t, h)
+}
@@ -0,0 +1,323 @@
+package network
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// ProtocolVersion is the version of the wire protocol spoken by this build.
+// Peers must agree on it exactly; bump it whenever a change would make an
+// older peer misinterpret messages.
+//
+// Version 2 replaced the textual input_update payload with InputCommand,
+// version 3 added session tokens to the handshake, version 4 added handshake
+// nonces and seals every later datagram with the session key, version 5
+// added handshake cookies, version 6 added the server's clock to pongs,
+// version 7 has servers ping their clients too, version 8 added fragments,
+// version 9 replaced the invite code in handshakes with a proof of it.
+const ProtocolVersion uint16 = 9
+
+// BuildID identifies the client build in handshakes and server logs.
+// Override it at link time with -ldflags "-X pong-multiplayer/network.BuildID=...".
+var BuildID = "dev"
+
+// Capability is a bit set of optional protocol features.
+type Capability uint32
+
+const (
+	// CapabilitySnapshotV1 means the peer understands versioned state snapshots.
+	CapabilitySnapshotV1 Capability = 1 << iota
+	// CapabilityDeltaSnapshots means the peer decodes MessageTypeStateDelta
+	// and acknowledges snapshots with MessageTypeSnapshotAck.
+	CapabilityDeltaSnapshots
+	// CapabilityEncryption means sealed datagrams are encrypted as well as
+	// authenticated.
+	CapabilityEncryption
+)
+
+// SupportedCapabilities is the set of capabilities implemented by this build.
+const SupportedCapabilities = CapabilitySnapshotV1 | CapabilityDeltaSnapshots | CapabilityEncryption
+
+// Has reports whether all capabilities in other are present in c.
+func (c Capability) Has(other Capability) bool {
+	return c&other == other
+}
+
+// Role is the part a client plays in the match, assigned in the handshake.
+type Role uint8
+
+const (
+	RolePlayerLeft Role = iota
+	RolePlayerRight
+	// RoleSpectator receives state broadcasts but controls no paddle.
+	RoleSpectator
+)
+
+// roleForSlot returns the role of the client holding slot, where -1 means
+// no paddle.
+func roleForSlot(slot int) Role {
+	if slot < 0 {
+		return RoleSpectator
+	}
+	return Role(slot)
+}
+
+// Slot returns the paddle controlled in role r (0 = left, 1 = right),
+// or -1 for a spectator.
+func (r Role) Slot() int {
+	if r == RoleSpectator {
+		return -1
+	}
+	return int(r)
+}
+
+func (r Role) String() string {
+	switch r {
+	case RolePlayerLeft:
+		return "player-left"
+	case RolePlayerRight:
+		return "player-right"
+	case RoleSpectator:
+		return "spectator"
+	default:
+		return "unknown"
+	}
+}
+
+// SessionToken identifies a client's session on the server across address
+// changes. The zero token means "no session".
+type SessionToken [16]byte
+
+// IsZero reports whether t is the zero token.
+func (t SessionToken) IsZero() bool {
+	return t == SessionToken{}
+}
+
+func newSessionToken() (SessionToken, error) {
+	var t SessionToken
+	_, err := rand.Read(t[:])
+	return t, err
+}
+
+// Nonce is a random value each side contributes to the session key.
+type Nonce [16]byte
+
+func newNonce() (Nonce, error) {
+	var n Nonce
+	_, err := rand.Read(n[:])
+	return n, err
+}
+
+// Handshake is the payload of a MessageTypeHandshake message.
+type Handshake struct {
+	ProtocolVersion uint16
+	BuildID         string
+	Capabilities    Capability
+	// InviteProof proves that the client knows the invite code, which
+	// itself never crosses the network; see inviteProof.
+	InviteProof [32]byte
+	// SessionToken is the token from an earlier HandshakeReply when
+	// reconnecting, or zero for a fresh join.
+	SessionToken SessionToken
+	// Nonce is the client's contribution to the session key.
+	Nonce Nonce
+	// Cookie echoes the server's MessageTypeCookie challenge; zero on the
+	// first attempt.
+	Cookie Cookie
+	// SealedInvite is the invite code encrypted for a Lobby, which needs it
+	// to find the room, with the key that came with its cookie. It is
+	// empty for other servers.
+	SealedInvite []byte
+}
+
+// HandshakeReply is the payload of a MessageTypeHandshakeSuccess message.
+type HandshakeReply struct {
+	ProtocolVersion uint16
+	// Capabilities is the negotiated set: those supported by both peers.
+	Capabilities Capability
+	// Role is the part assigned to the client.
+	Role Role
+	// SessionToken lets the client reclaim its slot with a new handshake
+	// after a network interruption.
+	SessionToken SessionToken
+	// Nonce is the server's contribution to the session key.
+	Nonce Nonce
+}
+
+// ErrorReason classifies a MessageTypeError message.
+type ErrorReason uint8
+
+const (
+	ErrorReasonUnknown ErrorReason = iota
+	ErrorReasonMalformed
+	ErrorReasonInvalidInviteCode
+	ErrorReasonVersionMismatch
+	ErrorReasonMissingCapability
+	ErrorReasonLobbyFull
+	ErrorReasonLockedOut
+)
+
+func (r ErrorReason) String() string {
+	switch r {
+	case ErrorReasonMalformed:
+		return "malformed message"
+	case ErrorReasonInvalidInviteCode:
+		return "invalid invite code"
+	case ErrorReasonVersionMismatch:
+		return "protocol version mismatch"
+	case ErrorReasonMissingCapability:
+		return "missing capability"
+	case ErrorReasonLobbyFull:
+		return "lobby full"
+	case ErrorReasonLockedOut:
+		return "too many wrong invite codes, try again later"
+	default:
+		return "unknown error"
+	}
+}
+
+// ProtocolError is the payload of a MessageTypeError message. It is also
+// returned by Client.Connect when the server rejects the handshake.
+type ProtocolError struct {
+	Reason ErrorReason
+	Detail string
+}
+
+func (e *ProtocolError) Error() string {
+	if e.Detail == "" {
+		return e.Reason.String()
+	}
+	return fmt.Sprintf("%s: %s", e.Reason, e.Detail)
+}
+
+// EncodeHandshake produces the binary representation of a handshake:
+// 2 bytes for the protocol version, the build id, 4 bytes of capabilities,
+// 32 bytes of invite proof, 16 bytes of session token, 16 bytes of nonce,
+// 16 bytes of cookie and the sealed invite code. Strings are prefixed with
+// a 1 byte length, the sealed invite code with 2 bytes.
+func EncodeHandshake(h Handshake) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.BigEndian, h.ProtocolVersion); err != nil {
+		return nil, err
+	}
+	if err := writeString(buf, h.BuildID); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buf, binary.BigEndian, h.Capabilities); err != nil {
+		return nil, err
+	}
+	buf.Write(h.InviteProof[:])
+	buf.Write(h.SessionToken[:])
+	buf.Write(h.Nonce[:])
+	buf.Write(h.Cookie[:])
+	if len(h.SealedInvite) > 0xFFFF {
+		return nil, fmt.Errorf("sealed invite code too long: %d bytes", len(h.SealedInvite))
+	}
+	binary.Write(buf, binary.BigEndian, uint16(len(h.SealedInvite)))
+	buf.Write(h.SealedInvite)
+	return buf.Bytes(), nil
+}
+
+// DecodeHandshake converts a binary handshake back into a Handshake.
+func DecodeHandshake(data []byte) (Handshake, error) {
+	var h Handshake
+	buf := bytes.NewReader(data)
+	if err := binary.Read(buf, binary.BigEndian, &h.ProtocolVersion); err != nil {
+		return h, err
+	}
+	var err error
+	if h.BuildID, err = readString(buf); err != nil {
+		return h, err
+	}
+	if err := binary.Read(buf, binary.BigEndian, &h.Capabilities); err != nil {
+		return h, err
+	}
+	if _, err := io.ReadFull(buf, h.InviteProof[:]); err != nil {
+		return h, err
+	}
+	if _, err := io.ReadFull(buf, h.SessionToken[:]); err != nil {
+		return h, err
+	}
+	if _, err := io.ReadFull(buf, h.Nonce[:]); err != nil {
+		return h, err
+	}
+	if _, err := io.ReadFull(buf, h.Cookie[:]); err != nil {
+		return h, err
+	}
+	var n uint16
+	if err := binary.Read(buf, binary.BigEndian, &n); err != nil {
+		return h, err
+	}
+	h.SealedInvite = make([]byte, n)
+	if _, err := io.ReadFull(buf, h.SealedInvite); err != nil {
+		return h, err
+	}
+	return h, nil
+}
+
+// EncodeHandshakeReply produces the binary representation of a handshake reply.
+func EncodeHandshakeReply(r HandshakeReply) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.BigEndian, r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeHandshakeReply converts a binary handshake reply back into a HandshakeReply.
+func DecodeHandshakeReply(data []byte) (HandshakeReply, error) {
+	var r HandshakeReply
+	err := binary.Read(bytes.NewReader(data), binary.BigEndian, &r)
+	return r, err
+}
+
+// EncodeError produces the binary representation of a protocol error:
+// 1 byte for the reason followed by a length-prefixed detail string.
+func EncodeError(e *ProtocolError) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := buf.WriteByte(byte(e.Reason)); err != nil {
+		return nil, err
+	}
+	if err := writeString(buf, e.Detail); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeError converts a binary protocol error back into a ProtocolError.
+func DecodeError(data []byte) (*ProtocolError, error) {
+	buf := bytes.NewReader(data)
+	reason, err := buf.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	detail, err := readString(buf)
+	if err != nil {
+		return nil, err
+	}
+	return &ProtocolError{Reason: ErrorReason(reason), Detail: detail}, nil
+}
+
+func writeString(buf *bytes.Buffer, s string) error {
+	if len(s) > 255 {
+		return fmt.Errorf("string too long: %d bytes", len(s))
+	}
+	buf.WriteByte(byte(len(s)))
+	_, err := buf.WriteString(s)
+	return err
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	n, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,31 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// InputCommand is the payload of a MessageTypeInputUpdate message: one
+// frame of paddle input. The message's Seq is the input sequence number,
+// which the server echoes back in State.InputSeq once it has applied it.
+type InputCommand struct {
+	Direction int8    // -1 for up, +1 for down, 0 for no input
+	DeltaTime float32 // seconds the input was held for
+}
+
+// EncodeInput produces the binary representation of an input command:
+// 1 byte for the direction followed by 4 bytes for the duration.
+func EncodeInput(in InputCommand) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.BigEndian, in); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeInput converts a binary input command back into an InputCommand.
+func DecodeInput(data []byte) (InputCommand, error) {
+	var in InputCommand
+	err := binary.Read(bytes.NewReader(data), binary.BigEndian, &in)
+	return in, err
+}
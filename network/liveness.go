@@ -0,0 +1,155 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// defaultClientTimeout is the default for Server.ClientTimeout. Clients
+// ping once per second, so this tolerates a few lost pings.
+const defaultClientTimeout = 5 * time.Second
+
+// defaultReconnectGrace is the default for Server.ReconnectGrace.
+const defaultReconnectGrace = 30 * time.Second
+
+// reservation keeps a timed-out client's slot until it reconnects or
+// the grace period ends.
+type reservation struct {
+	slot    int
+	expires time.Time
+}
+
+// LeaveReason tells why a client left the server.
+type LeaveReason int
+
+const (
+	// LeaveReasonDisconnect means the client sent MessageTypeDisconnect.
+	LeaveReasonDisconnect LeaveReason = iota
+	// LeaveReasonTimeout means nothing was heard from the client for
+	// longer than Server.ClientTimeout.
+	LeaveReasonTimeout
+	// LeaveReasonKicked means the server removed the client with Kick or Ban.
+	LeaveReasonKicked
+)
+
+func (r LeaveReason) String() string {
+	switch r {
+	case LeaveReasonDisconnect:
+		return "disconnected"
+	case LeaveReasonTimeout:
+		return "timed out"
+	case LeaveReasonKicked:
+		return "was kicked"
+	default:
+		return "unknown"
+	}
+}
+
+// touch records that a datagram from addr has just arrived.
+func (s *Server) touch(addr *net.UDPAddr) {
+	s.Lock.Lock()
+	if sess, ok := s.sessions[addr.String()]; ok {
+		sess.lastSeen = time.Now()
+	}
+	s.Lock.Unlock()
+}
+
+// removeClient forgets the client at key, frees its slot and reports it
+// through OnClientLeft. The slot of a client that timed out is reserved for
+// its session token for Server.ReconnectGrace. Unknown clients are ignored.
+func (s *Server) removeClient(key string, reason LeaveReason) {
+	s.Lock.Lock()
+	sess, ok := s.sessions[key]
+	if !ok {
+		s.Lock.Unlock()
+		return
+	}
+	slot := s.clientIndexLocked(key)
+	if slot >= 0 {
+		s.slots[slot] = ""
+		if reason == LeaveReasonTimeout && s.ReconnectGrace > 0 {
+			s.reservations[sess.token] = reservation{slot: slot, expires: time.Now().Add(s.ReconnectGrace)}
+		}
+	}
+	delete(s.sessions, key)
+	delete(s.Clients, key)
+	s.Lock.Unlock()
+
+	st := sess.stats.get()
+	fmt.Printf("Client %s %s (%s, rtt %v, loss %.1f%%, %d bytes in, %d out)\n",
+		key, reason, roleForSlot(slot), st.RTT.Round(time.Millisecond), 100*st.Loss(), st.BytesIn, st.BytesOut)
+	if s.OnClientLeft != nil {
+		s.OnClientLeft(sess.addr, slot, reason)
+	}
+	s.broadcastSpectators()
+}
+
+// livenessLoop periodically drops clients that have gone silent and pings
+// the others to measure their RTT.
+func (s *Server) livenessLoop() {
+	var pingSeq uint32
+	for wait(s.done, time.Second) {
+		now := time.Now()
+		var expired []string
+		var live []*session
+		s.Lock.Lock()
+		for key, sess := range s.sessions {
+			if now.Sub(sess.lastSeen) > s.ClientTimeout {
+				expired = append(expired, key)
+			} else {
+				live = append(live, sess)
+			}
+		}
+		for token, r := range s.reservations {
+			if now.After(r.expires) {
+				delete(s.reservations, token)
+				fmt.Printf("Reservation of slot %d expired\n", r.slot)
+			}
+		}
+		s.Lock.Unlock()
+		for _, key := range expired {
+			s.removeClient(key, LeaveReasonTimeout)
+		}
+		pingSeq++
+		buf := new(bytes.Buffer)
+		binary.Write(buf, binary.BigEndian, now.UnixNano())
+		ping, _ := EncodeMessage(Message{Type: MessageTypePing, Seq: pingSeq, Data: buf.Bytes()})
+		for _, sess := range live {
+			sess.secure.send(ping)
+		}
+	}
+}
+
+// reclaimSlotLocked gives key the slot of the session identified by token,
+// either reserved after a timeout or still held by the client's previous
+// address. It reports whether a slot was reclaimed.
+func (s *Server) reclaimSlotLocked(key string, token SessionToken) bool {
+	if token.IsZero() {
+		return false
+	}
+	if r, ok := s.reservations[token]; ok {
+		delete(s.reservations, token)
+		if time.Now().After(r.expires) {
+			return false
+		}
+		s.slots[r.slot] = key
+		return true
+	}
+	for oldKey, sess := range s.sessions {
+		if sess.token != token {
+			continue
+		}
+		slot := s.clientIndexLocked(oldKey)
+		delete(s.sessions, oldKey)
+		delete(s.Clients, oldKey)
+		if slot < 0 {
+			return false
+		}
+		s.slots[slot] = key
+		return true
+	}
+	return false
+}
@@ -0,0 +1,243 @@
+package network
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultMaxRooms is the default for Lobby.MaxRooms.
+	defaultMaxRooms = 256
+	// defaultMaxRoomsPerSource is the default for Lobby.MaxRoomsPerSource.
+	defaultMaxRoomsPerSource = 2
+)
+
+// Lobby hosts many independent rooms on one UDP socket. Each room is a
+// Server with its own invite code and clients; a handshake opens the room
+// named by its invite code if there is none yet, and every later datagram is
+// routed to the room its sender joined. Opening a room counts as a wrong
+// invite code towards the lockout of its source, so that nobody can probe
+// for the codes of open rooms.
+type Lobby struct {
+	Address string
+	// MaxRooms caps the number of open rooms; handshakes that would open
+	// another one are rejected with ErrorReasonLobbyFull.
+	MaxRooms int
+	// MaxRoomsPerSource caps the number of open rooms opened from one
+	// source IP in the same way.
+	MaxRoomsPerSource int
+	// Encrypt is passed on to every room as Server.Encrypt.
+	Encrypt bool
+	// Transport opens the lobby's socket; NewLobby sets UDPTransport.
+	Transport Transport
+	// Conditions simulate a bad network on the lobby's socket.
+	Conditions Conditions
+	// OnRoomOpened is called with each new room before its first handshake
+	// is handled, so the application can set the room's callbacks and start
+	// its game. The room's Done channel is closed once it is empty again.
+	OnRoomOpened func(room *Server)
+
+	conn  Conn
+	guard *guard
+	// inviteKey decrypts the invite codes that clients seal for the lobby;
+	// its public half goes out with every cookie.
+	inviteKey *ecdh.PrivateKey
+	done      chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+	// rooms maps invite codes to rooms, routes client addresses to the
+	// room they joined and openers invite codes to the source IP that
+	// opened the room.
+	rooms   map[string]*Server
+	routes  map[string]*Server
+	openers map[string]string
+	// opening is the room being opened, which stays open while it is
+	// still empty because its first handshake is yet to be handled.
+	opening *Server
+}
+
+func NewLobby(address string) *Lobby {
+	inviteKey, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	return &Lobby{
+		Address:           address,
+		MaxRooms:          defaultMaxRooms,
+		MaxRoomsPerSource: defaultMaxRoomsPerSource,
+		rooms:             make(map[string]*Server),
+		routes:            make(map[string]*Server),
+		openers:           make(map[string]string),
+		guard:             newGuard(),
+		inviteKey:         inviteKey,
+		done:              make(chan struct{}),
+		Transport:         UDPTransport{},
+	}
+}
+
+// Start serves rooms until the lobby is closed, by Close or by ctx being
+// done. It returns once the rooms have been closed and their goroutines
+// have stopped.
+func (l *Lobby) Start(ctx context.Context) error {
+	conn, err := l.Transport.Listen(l.Address)
+	if err != nil {
+		return err
+	}
+	l.conn = Condition(conn, l.Conditions)
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	defer stop()
+	fmt.Println("Lobby listening on", l.Address)
+	closed := make(chan struct{})
+	go func() {
+		l.closeIdleRooms()
+		close(closed)
+	}()
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			if isClosed(l.done) {
+				break
+			}
+			fmt.Println("Error reading UDP:", err)
+			continue
+		}
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		msg, err := DecodeMessage(data)
+		if err != nil || !l.guard.admit(addr, msg.Type) {
+			continue
+		}
+		l.handleMessage(addr, msg)
+	}
+	<-closed
+	l.mu.Lock()
+	rooms := l.rooms
+	l.rooms = make(map[string]*Server)
+	l.mu.Unlock()
+	for _, room := range rooms {
+		room.Close()
+	}
+	fmt.Println("Lobby on", l.Address, "closed")
+	return nil
+}
+
+// Close stops the lobby, closing its rooms and its socket.
+func (l *Lobby) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+// handleMessage passes a datagram on to its room. The lobby's lock is only
+// held to look up or open the room, not while the room handles the
+// datagram or OnRoomOpened runs.
+func (l *Lobby) handleMessage(addr *net.UDPAddr, msg Message) {
+	if msg.Type != MessageTypeHandshake {
+		// Datagrams from addresses that never joined a room are dropped.
+		l.mu.Lock()
+		room, ok := l.routes[addr.String()]
+		l.mu.Unlock()
+		if ok {
+			room.handleDatagram(l.conn, addr, msg)
+		}
+		return
+	}
+	// Rooms are only opened for handshakes that come with a valid cookie.
+	hs, ok := readHandshake(l.conn, addr, msg)
+	if !ok {
+		return
+	}
+	if !l.guard.checkCookie(addr, hs.Cookie) {
+		writeCookie(l.conn, addr, l.guard.currentCookie(addr), l.inviteKey.PublicKey().Bytes())
+		return
+	}
+	// The room checks the invite proof as well, as for any server.
+	code, err := openInvite(l.inviteKey, hs.SealedInvite)
+	if err != nil || code == "" {
+		writeError(l.conn, addr, &ProtocolError{Reason: ErrorReasonInvalidInviteCode})
+		return
+	}
+	room, opened, refused := l.openRoom(addr, code)
+	if refused != nil {
+		writeError(l.conn, addr, refused)
+		return
+	}
+	if opened && l.OnRoomOpened != nil {
+		l.OnRoomOpened(room)
+	}
+	room.handleDatagram(l.conn, addr, msg)
+	joined := room.hasSession(addr.String())
+	l.mu.Lock()
+	if joined {
+		l.routes[addr.String()] = room
+	}
+	if l.opening == room {
+		l.opening = nil
+	}
+	l.mu.Unlock()
+}
+
+// openRoom returns the room for code, opening it for addr if there is none
+// yet, and whether it was opened.
+func (l *Lobby) openRoom(addr *net.UDPAddr, code string) (*Server, bool, *ProtocolError) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if room, ok := l.rooms[code]; ok {
+		return room, false, nil
+	}
+	if l.guard.lockedOut(addr) {
+		return nil, false, &ProtocolError{Reason: ErrorReasonLockedOut}
+	}
+	if len(l.rooms) >= l.MaxRooms || l.roomsOpenedLocked(addr) >= l.MaxRoomsPerSource {
+		return nil, false, &ProtocolError{Reason: ErrorReasonLobbyFull}
+	}
+	// A code nobody uses yet may just as well be a guess.
+	l.guard.inviteFailed(addr)
+	room := NewServer(l.Address, code)
+	room.Encrypt = l.Encrypt
+	room.guard = l.guard
+	room.attach(l.conn)
+	l.rooms[code] = room
+	l.openers[code] = addr.IP.String()
+	l.opening = room
+	fmt.Printf("Room %s opened (%d open)\n", code, len(l.rooms))
+	return room, true, nil
+}
+
+// roomsOpenedLocked returns the number of open rooms opened from the source
+// IP of addr.
+func (l *Lobby) roomsOpenedLocked(addr *net.UDPAddr) int {
+	n := 0
+	for _, ip := range l.openers {
+		if ip == addr.IP.String() {
+			n++
+		}
+	}
+	return n
+}
+
+// closeIdleRooms periodically forgets routes to clients that have left
+// their room and closes rooms that have become empty or were closed by the
+// application. Once the lobby is closed, it closes the socket and returns.
+func (l *Lobby) closeIdleRooms() {
+	for wait(l.done, time.Second) {
+		l.mu.Lock()
+		for key, room := range l.routes {
+			if !room.hasSession(key) || isClosed(room.done) {
+				delete(l.routes, key)
+			}
+		}
+		for code, room := range l.rooms {
+			if room != l.opening && room.idle() || isClosed(room.done) {
+				delete(l.rooms, code)
+				delete(l.openers, code)
+				room.Close()
+				fmt.Printf("Room %s closed (%d open)\n", code, len(l.rooms))
+			}
+		}
+		l.mu.Unlock()
+	}
+	l.conn.Close()
+}
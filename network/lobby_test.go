@@ -0,0 +1,57 @@
+package network
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLobbyRooms(t *testing.T) {
+	mem := NewMemoryNetwork()
+	lobby := NewLobby("127.0.0.1:9000")
+	lobby.Transport = mem
+	var mu sync.Mutex
+	opened := make(map[string]int)
+	joined := make(chan string, 10)
+	lobby.OnRoomOpened = func(room *Server) {
+		mu.Lock()
+		opened[room.ExpectedInviteCode]++
+		mu.Unlock()
+		// A room being set up is neither closed as empty nor holds up
+		// the lobby.
+		time.Sleep(10 * time.Millisecond)
+		room.OnClientJoined = func(addr *net.UDPAddr, slot int) {
+			joined <- room.ExpectedInviteCode
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- lobby.Start(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Start: %v", err)
+		}
+	}()
+
+	for _, code := range []string{"ROOM1", "ROOM1", "ROOM2"} {
+		if _, err := joinMatch(t, mem, code, nil); err != nil {
+			t.Fatalf("Connect to %s: %v", code, err)
+		}
+		select {
+		case got := <-joined:
+			if got != code {
+				t.Errorf("joined room %s, want %s", got, code)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("OnClientJoined not called for %s", code)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if opened["ROOM1"] != 1 || opened["ROOM2"] != 1 || len(opened) != 2 {
+		t.Errorf("rooms opened: %v, want ROOM1 and ROOM2 once each", opened)
+	}
+}
@@ -0,0 +1,291 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"pong-multiplayer/shared"
+)
+
+// startMatch starts a server on a MemoryNetwork, after setup has set its
+// callbacks unless it is nil, and returns it along with the network; the
+// server is closed when the test ends.
+func startMatch(t *testing.T, setup func(s *Server)) (*Server, *MemoryNetwork) {
+	t.Helper()
+	mem := NewMemoryNetwork()
+	server := NewServer("127.0.0.1:9000", "ABC123")
+	server.Transport = mem
+	if setup != nil {
+		setup(server)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.Start(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Start: %v", err)
+		}
+	})
+	return server, mem
+}
+
+// joinMatch connects a client over mem, after setup has set its callbacks
+// unless it is nil; the client is closed when the test ends.
+func joinMatch(t *testing.T, mem *MemoryNetwork, inviteCode string, setup func(c *Client)) (*Client, error) {
+	t.Helper()
+	client := NewClient("127.0.0.1:9000")
+	client.Transport = mem
+	if setup != nil {
+		setup(client)
+	}
+	t.Cleanup(func() { client.Close() })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return client, client.Connect(ctx, inviteCode)
+}
+
+func TestMatchHandshake(t *testing.T) {
+	server, mem := startMatch(t, nil)
+
+	var protocolErr *ProtocolError
+	if _, err := joinMatch(t, mem, "WRONG", nil); !errors.As(err, &protocolErr) || protocolErr.Reason != ErrorReasonInvalidInviteCode {
+		t.Errorf("Connect with a wrong invite code = %v, want ErrorReasonInvalidInviteCode", err)
+	}
+
+	for _, want := range []Role{RolePlayerLeft, RolePlayerRight, RoleSpectator} {
+		client, err := joinMatch(t, mem, "ABC123", nil)
+		if err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+		if client.Role != want {
+			t.Errorf("Role = %v, want %v", client.Role, want)
+		}
+		if client.Token == (SessionToken{}) {
+			t.Error("no session token")
+		}
+	}
+	if n, m := server.PlayerCount(), server.SpectatorCount(); n != 2 || m != 1 {
+		t.Errorf("%d players and %d spectators, want 2 and 1", n, m)
+	}
+}
+
+func TestMatchInputAndState(t *testing.T) {
+	type input struct {
+		slot int
+		seq  uint32
+		in   InputCommand
+	}
+	inputs := make(chan input, 10)
+	server, mem := startMatch(t, func(s *Server) {
+		s.InputUpdate = func(addr *net.UDPAddr, msg Message) {
+			in, err := DecodeInput(msg.Data)
+			if err != nil {
+				t.Errorf("DecodeInput: %v", err)
+			}
+			inputs <- input{s.ClientIndex(addr), msg.Seq, in}
+		}
+	})
+
+	var states [2]chan shared.State
+	for i := range states {
+		states[i] = make(chan shared.State, 10)
+		client, err := joinMatch(t, mem, "ABC123", func(c *Client) {
+			c.OnStateUpdate = func(s shared.State) { states[i] <- s }
+		})
+		if err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+		if i == 1 {
+			data, _ := EncodeInput(InputCommand{Direction: -1, DeltaTime: 0.016})
+			if err := client.Send(Message{Type: MessageTypeInputUpdate, Seq: 7, Data: data}); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+		}
+	}
+
+	select {
+	case got := <-inputs:
+		want := input{1, 7, InputCommand{Direction: -1, DeltaTime: 0.016}}
+		if got != want {
+			t.Errorf("InputUpdate got %+v, want %+v", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("input never reached the server")
+	}
+
+	state := shared.State{BallX: 400, BallY: 300, P2Y: 200, ScoreLeft: 1, Tick: 99, InputSeqs: [2]uint32{0, 7}}
+	server.BroadcastState(state)
+	for slot, ch := range states {
+		select {
+		case got := <-ch:
+			if got.BallX != state.BallX || got.P2Y != state.P2Y || got.ScoreLeft != state.ScoreLeft || got.Tick != state.Tick {
+				t.Errorf("slot %d received %+v, want %+v", slot, got, state)
+			}
+			// Each player hears back about its own input only.
+			if got.InputSeq != state.InputSeqs[slot] {
+				t.Errorf("slot %d received InputSeq %d, want %d", slot, got.InputSeq, state.InputSeqs[slot])
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("slot %d never received the state", slot)
+		}
+	}
+}
+
+func TestMatchBadNetwork(t *testing.T) {
+	server, mem := startMatch(t, func(s *Server) {
+		// Conditioning the server's socket affects both directions.
+		s.Conditions = Conditions{
+			Latency:   5 * time.Millisecond,
+			Jitter:    10 * time.Millisecond,
+			Loss:      0.2,
+			Duplicate: 0.2,
+			Reorder:   0.3,
+			Seed:      42,
+		}
+	})
+	scores := make(chan int, 100)
+	var latest atomic.Uint64
+	_, err := joinMatch(t, mem, "ABC123", func(c *Client) {
+		c.OnMessage = func(msg Message) {
+			if msg.Type == MessageTypeScore {
+				left, _, _ := DecodeScore(msg.Data)
+				scores <- left
+			}
+		}
+		c.OnStateUpdate = func(s shared.State) {
+			if s.Tick < latest.Load() {
+				t.Errorf("state of tick %d delivered after tick %d", s.Tick, latest.Load())
+			}
+			if s.BallX != float32(s.Tick) {
+				t.Errorf("state of tick %d has the ball at %v, want %d", s.Tick, s.BallX, s.Tick)
+			}
+			latest.Store(s.Tick)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	// Reliable messages arrive once each and in order.
+	const events = 30
+	for i := range events {
+		server.Broadcast(Message{Type: MessageTypeScore, Data: EncodeScore(i, 0)})
+	}
+	for want := range events {
+		select {
+		case got := <-scores:
+			if got != want {
+				t.Fatalf("score %d received in place of %d", got, want)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("score %d never arrived", want)
+		}
+	}
+
+	// Snapshots, full or delta, converge on the server's state.
+	const last = 300
+	for tick := uint64(1); ; tick++ {
+		server.BroadcastState(shared.State{BallX: float32(min(tick, last)), Tick: min(tick, last)})
+		if latest.Load() == last {
+			break
+		}
+		if tick > 3000 {
+			t.Fatalf("client at tick %d, want %d", latest.Load(), last)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	select {
+	case got := <-scores:
+		t.Errorf("score %d received twice", got)
+	default:
+	}
+}
+
+func TestClientNewSession(t *testing.T) {
+	first, mem := startMatch(t, nil)
+	states := make(chan shared.State, 10)
+	client, err := joinMatch(t, mem, "ABC123", func(c *Client) {
+		c.OnStateUpdate = func(s shared.State) { states <- s }
+	})
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	receive := func(want uint64) {
+		t.Helper()
+		select {
+		case got := <-states:
+			if got.Tick != want {
+				t.Errorf("received tick %d, want %d", got.Tick, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("state of tick %d never received", want)
+		}
+	}
+	for tick := uint64(1); tick <= 5; tick++ {
+		first.BroadcastState(shared.State{Tick: tick})
+		receive(tick)
+	}
+
+	// Another server numbers its snapshots from one again.
+	second := NewServer("127.0.0.1:9001", "ABC123")
+	second.Transport = mem
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- second.Start(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	client.Address = "127.0.0.1:9001"
+	if err := client.Reconnect(ctx); err != nil {
+		t.Fatalf("Reconnect: %v", err)
+	}
+	second.BroadcastState(shared.State{Tick: 100})
+	receive(100)
+}
+
+func TestServeBeforeStart(t *testing.T) {
+	server := NewServer("127.0.0.1:9000", "ABC123")
+	remote := NewMemoryNetwork()
+	server.Transport = remote
+	// Both networks number their clients' ports from one; use up the first
+	// one of remote so that the two clients' addresses differ.
+	if _, err := remote.Dial(nil, "127.0.0.1:1"); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	// The host's own player connects in memory before the server listens.
+	local := NewMemoryNetwork()
+	conn, err := local.Listen(server.Address)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go server.Serve(conn)
+	host, err := joinMatch(t, local, "ABC123", nil)
+	if err != nil {
+		t.Fatalf("Connect in memory: %v", err)
+	}
+	if host.Role != RolePlayerLeft {
+		t.Errorf("host's player got %v, want %v", host.Role, RolePlayerLeft)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.Start(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	client, err := joinMatch(t, remote, "ABC123", nil)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	if client.Role != RolePlayerRight {
+		t.Errorf("remote player got %v, want %v", client.Role, RolePlayerRight)
+	}
+}
@@ -3,6 +3,7 @@ package network
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 )
 
 // MessageType defines our message types.
@@ -16,6 +17,45 @@ const (
 	MessageTypeStateUpdate      MessageType = 5
 	MessageTypePing             MessageType = 6
 	MessageTypePong             MessageType = 7
+	// MessageTypeReliable wraps another encoded message sent over the
+	// reliable channel; Seq is the reliable sequence number.
+	MessageTypeReliable MessageType = 8
+	// MessageTypeAck acknowledges the reliable message whose sequence is Seq.
+	MessageTypeAck        MessageType = 9
+	MessageTypeScore      MessageType = 10
+	MessageTypeMatchStart MessageType = 11
+	MessageTypeMatchEnd   MessageType = 12
+	// MessageTypeStateDelta carries only the fields that changed relative
+	// to a snapshot the client has acknowledged.
+	MessageTypeStateDelta MessageType = 13
+	// MessageTypeSnapshotAck acknowledges the state snapshot whose sequence is Seq.
+	MessageTypeSnapshotAck MessageType = 14
+	// MessageTypeDisconnect announces that the sender is leaving.
+	MessageTypeDisconnect MessageType = 15
+	// MessageTypeSpectators announces how many spectators are watching.
+	MessageTypeSpectators MessageType = 16
+	// MessageTypeRegister announces a host or joiner to a rendezvous server.
+	MessageTypeRegister MessageType = 17
+	// MessageTypePeerInfo tells a registered peer the public endpoint of
+	// the peer it was paired with.
+	MessageTypePeerInfo MessageType = 18
+	// MessageTypePunch opens a NAT mapping towards a peer; it carries no
+	// payload and is ignored on arrival.
+	MessageTypePunch MessageType = 19
+	// MessageTypeAnnounce advertises a game on the LAN.
+	MessageTypeAnnounce MessageType = 20
+	// MessageTypeSecure carries another encoded message sealed with the
+	// session key; Seq is the sender's packet counter.
+	MessageTypeSecure MessageType = 21
+	// MessageTypeCookie answers a handshake without a valid cookie with
+	// the Cookie to send it again with.
+	MessageTypeCookie MessageType = 22
+	// MessageTypeFragment carries one chunk of an encoded message too large
+	// for a single datagram; Seq identifies the message being fragmented.
+	MessageTypeFragment MessageType = 23
+	// MessageTypeChat carries a chat line: its text from a client, or the
+	// sender and text as relayed by the server.
+	MessageTypeChat MessageType = 24
 )
 
 // Message now includes a sequence number.
@@ -25,9 +65,15 @@ type Message struct {
 	Data []byte
 }
 
+// messageHeaderSize is the size of an encoded Message without its payload.
+const messageHeaderSize = 7
+
 // EncodeMessage produces a binary representation: 1 byte for type,
 // 4 bytes for sequence, 2 bytes for data length, then the payload.
 func EncodeMessage(msg Message) ([]byte, error) {
+	if len(msg.Data) > 0xFFFF {
+		return nil, fmt.Errorf("message payload of %d bytes exceeds the maximum of %d", len(msg.Data), 0xFFFF)
+	}
 	buf := new(bytes.Buffer)
 	if err := binary.Write(buf, binary.BigEndian, msg.Type); err != nil {
 		return nil, err
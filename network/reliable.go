@@ -0,0 +1,168 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ReliableTypes lists the message types that Client.Send, Server.Send and
+// Server.Broadcast deliver over the reliable, ordered channel. Every other
+// type, including MessageTypeStateUpdate, stays fire-and-forget.
+var ReliableTypes = map[MessageType]bool{
+	MessageTypeScore:      true,
+	MessageTypeMatchStart: true,
+	MessageTypeMatchEnd:   true,
+	MessageTypeSpectators: true,
+	MessageTypeChat:       true,
+}
+
+const (
+	// reliableResendInterval is how long a message may stay unacknowledged
+	// before it is retransmitted.
+	reliableResendInterval = 200 * time.Millisecond
+	// reliableWindow bounds both the number of unacknowledged outgoing
+	// messages and how far ahead of the next expected one an incoming
+	// message may be buffered.
+	reliableWindow = 256
+)
+
+// ErrReliableBacklog is returned by ReliableChannel.Send when too many
+// messages are still waiting for an acknowledgement.
+var ErrReliableBacklog = errors.New("reliable channel backlog full")
+
+type reliablePending struct {
+	data   []byte
+	sentAt time.Time
+}
+
+// ReliableChannel provides acknowledged, retransmitted, duplicate-free and
+// ordered delivery of messages to a single peer on top of unreliable
+// datagrams. Each message is wrapped in a MessageTypeReliable carrying its
+// own sequence number and is resent until the peer answers with a
+// MessageTypeAck.
+type ReliableChannel struct {
+	mu       sync.Mutex
+	send     func(data []byte) error
+	nextSeq  uint32
+	pending  map[uint32]*reliablePending
+	expected uint32
+	received map[uint32]Message
+}
+
+// NewReliableChannel creates a channel that writes datagrams with send.
+func NewReliableChannel(send func(data []byte) error) *ReliableChannel {
+	return &ReliableChannel{
+		send:     send,
+		nextSeq:  1,
+		pending:  make(map[uint32]*reliablePending),
+		expected: 1,
+		received: make(map[uint32]Message),
+	}
+}
+
+// Send queues msg for reliable delivery and transmits it immediately.
+func (r *ReliableChannel) Send(msg Message) error {
+	inner, err := EncodeMessage(msg)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	if len(r.pending) >= reliableWindow {
+		r.mu.Unlock()
+		return ErrReliableBacklog
+	}
+	seq := r.nextSeq
+	r.nextSeq++
+	data, err := EncodeMessage(Message{Type: MessageTypeReliable, Seq: seq, Data: inner})
+	if err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	r.pending[seq] = &reliablePending{data: data, sentAt: time.Now()}
+	r.mu.Unlock()
+	return r.send(data)
+}
+
+// Receive acknowledges an incoming MessageTypeReliable and returns the
+// messages that are now deliverable in order. Duplicates and messages
+// that are already delivered return nothing.
+func (r *ReliableChannel) Receive(msg Message) []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if msg.Seq >= r.expected && msg.Seq-r.expected >= reliableWindow {
+		// Too far ahead to buffer; let the sender retransmit it later.
+		return nil
+	}
+	// Always acknowledge, so a lost ack doesn't cause endless retransmission.
+	r.ack(msg.Seq)
+	if msg.Seq < r.expected {
+		return nil
+	}
+	if _, dup := r.received[msg.Seq]; dup {
+		return nil
+	}
+	inner, err := DecodeMessage(msg.Data)
+	if err != nil {
+		fmt.Println("Error decoding reliable message:", err)
+		return nil
+	}
+	r.received[msg.Seq] = inner
+
+	var ready []Message
+	for {
+		m, ok := r.received[r.expected]
+		if !ok {
+			break
+		}
+		delete(r.received, r.expected)
+		ready = append(ready, m)
+		r.expected++
+	}
+	return ready
+}
+
+// HandleAck marks the reliable message acknowledged by ack as delivered.
+func (r *ReliableChannel) HandleAck(ack Message) {
+	r.mu.Lock()
+	delete(r.pending, ack.Seq)
+	r.mu.Unlock()
+}
+
+// Resend retransmits every message that has been waiting for an
+// acknowledgement for longer than the resend interval.
+func (r *ReliableChannel) Resend(now time.Time) {
+	r.mu.Lock()
+	var due [][]byte
+	for _, p := range r.pending {
+		if now.Sub(p.sentAt) >= reliableResendInterval {
+			p.sentAt = now
+			due = append(due, p.data)
+		}
+	}
+	r.mu.Unlock()
+	for _, data := range due {
+		if err := r.send(data); err != nil {
+			fmt.Println("Error resending reliable message:", err)
+		}
+	}
+}
+
+// Pending returns the number of messages not yet acknowledged by the peer.
+func (r *ReliableChannel) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.pending)
+}
+
+// ack sends a MessageTypeAck for seq. Must be called with r.mu held.
+func (r *ReliableChannel) ack(seq uint32) {
+	data, err := EncodeMessage(Message{Type: MessageTypeAck, Seq: seq})
+	if err != nil {
+		return
+	}
+	if err := r.send(data); err != nil {
+		fmt.Println("Error sending ack:", err)
+	}
+}
@@ -0,0 +1,127 @@
+package network
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// wire records the datagrams a ReliableChannel sends.
+type wire struct {
+	sent []Message
+}
+
+func (w *wire) send(data []byte) error {
+	msg, err := DecodeMessage(data)
+	if err != nil {
+		return err
+	}
+	w.sent = append(w.sent, msg)
+	return nil
+}
+
+// take returns and forgets the recorded datagrams.
+func (w *wire) take() []Message {
+	sent := w.sent
+	w.sent = nil
+	return sent
+}
+
+func TestReliableOrdering(t *testing.T) {
+	var out, back wire
+	sender := NewReliableChannel(out.send)
+	receiver := NewReliableChannel(back.send)
+	for i := byte(1); i <= 5; i++ {
+		if err := sender.Send(Message{Type: MessageTypeChat, Data: []byte{i}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sent := out.take()
+	if len(sent) != 5 {
+		t.Fatalf("sent %d datagrams, want 5", len(sent))
+	}
+
+	// Deliver out of order, with duplicates.
+	var got []byte
+	for _, i := range []int{2, 0, 0, 4, 1, 3, 2} {
+		for _, m := range receiver.Receive(sent[i]) {
+			if m.Type != MessageTypeChat {
+				t.Errorf("delivered type %v, want chat", m.Type)
+			}
+			got = append(got, m.Data...)
+		}
+	}
+	if string(got) != "\x01\x02\x03\x04\x05" {
+		t.Errorf("delivered %v, want 1 to 5 in order", got)
+	}
+
+	// Every datagram, duplicates included, is acknowledged.
+	acks := back.take()
+	if len(acks) != 7 {
+		t.Errorf("sent %d acks, want 7", len(acks))
+	}
+	for _, ack := range acks {
+		if ack.Type != MessageTypeAck {
+			t.Errorf("receiver sent type %v, want ack", ack.Type)
+		}
+		sender.HandleAck(ack)
+	}
+	if n := sender.Pending(); n != 0 {
+		t.Errorf("Pending = %d after all acks, want 0", n)
+	}
+}
+
+func TestReliableRetransmit(t *testing.T) {
+	var out, back wire
+	sender := NewReliableChannel(out.send)
+	receiver := NewReliableChannel(back.send)
+	if err := sender.Send(Message{Type: MessageTypeScore, Data: EncodeScore(1, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	// The first transmission is lost.
+	out.take()
+
+	now := time.Now()
+	sender.Resend(now)
+	if len(out.sent) != 0 {
+		t.Fatalf("resent %d datagrams before the interval, want 0", len(out.sent))
+	}
+	sender.Resend(now.Add(reliableResendInterval))
+	resent := out.take()
+	if len(resent) != 1 {
+		t.Fatalf("resent %d datagrams after the interval, want 1", len(resent))
+	}
+	if ready := receiver.Receive(resent[0]); len(ready) != 1 || ready[0].Type != MessageTypeScore {
+		t.Fatalf("Receive = %v, want the score message", ready)
+	}
+
+	// Once acknowledged, the message is not sent again.
+	for _, ack := range back.take() {
+		sender.HandleAck(ack)
+	}
+	sender.Resend(now.Add(10 * reliableResendInterval))
+	if len(out.sent) != 0 || sender.Pending() != 0 {
+		t.Errorf("resent %d datagrams with %d pending after the ack, want none", len(out.sent), sender.Pending())
+	}
+}
+
+func TestReliableWindow(t *testing.T) {
+	var out, back wire
+	sender := NewReliableChannel(out.send)
+	for range reliableWindow {
+		if err := sender.Send(Message{Type: MessageTypeChat}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sender.Send(Message{Type: MessageTypeChat}); !errors.Is(err, ErrReliableBacklog) {
+		t.Errorf("Send beyond the window = %v, want ErrReliableBacklog", err)
+	}
+
+	// A message too far ahead is neither buffered nor acknowledged.
+	receiver := NewReliableChannel(back.send)
+	far := out.sent[reliableWindow-1]
+	far.Seq = reliableWindow + 1
+	if ready := receiver.Receive(far); len(ready) != 0 || len(back.sent) != 0 {
+		t.Errorf("Receive far ahead = %v with %d acks, want nothing", ready, len(back.sent))
+	}
+}
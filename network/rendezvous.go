@@ -0,0 +1,334 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// registerInterval is how often a host refreshes its registration,
+	// which also keeps its NAT mapping towards the rendezvous server alive.
+	registerInterval = 2 * time.Second
+	// hostRegistrationTTL is how long a rendezvous server remembers a host
+	// that stopped refreshing its registration.
+	hostRegistrationTTL = 3 * registerInterval
+	// punchCount and punchInterval shape the burst of MessageTypePunch
+	// datagrams a host sends towards a newly paired joiner.
+	punchCount    = 10
+	punchInterval = 100 * time.Millisecond
+)
+
+// PeerRole tells a rendezvous server which side of a match a peer is on.
+type PeerRole uint8
+
+const (
+	PeerRoleHost PeerRole = iota
+	PeerRoleJoiner
+)
+
+// InviteID identifies an invite code to a rendezvous server without
+// revealing it.
+type InviteID [16]byte
+
+// NewInviteID returns the InviteID of inviteCode.
+func NewInviteID(inviteCode string) InviteID {
+	mac := hmac.New(sha256.New, []byte(inviteCode))
+	mac.Write([]byte("pong-multiplayer rendezvous"))
+	var id InviteID
+	copy(id[:], mac.Sum(nil))
+	return id
+}
+
+// Registration is the payload of a MessageTypeRegister message.
+type Registration struct {
+	Role   PeerRole
+	Invite InviteID
+}
+
+// EncodeRegistration produces the binary representation of a registration:
+// 1 byte for the role followed by 16 bytes of invite id.
+func EncodeRegistration(r Registration) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(byte(r.Role))
+	buf.Write(r.Invite[:])
+	return buf.Bytes(), nil
+}
+
+// DecodeRegistration converts a binary registration back into a Registration.
+func DecodeRegistration(data []byte) (Registration, error) {
+	buf := bytes.NewReader(data)
+	role, err := buf.ReadByte()
+	if err != nil {
+		return Registration{}, err
+	}
+	r := Registration{Role: PeerRole(role)}
+	if _, err := io.ReadFull(buf, r.Invite[:]); err != nil {
+		return Registration{}, err
+	}
+	return r, nil
+}
+
+// EncodePeerInfo produces the payload of a MessageTypePeerInfo message:
+// the peer's endpoint as a length-prefixed "host:port" string.
+func EncodePeerInfo(addr *net.UDPAddr) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := writeString(buf, addr.String()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodePeerInfo converts a MessageTypePeerInfo payload back into an endpoint.
+func DecodePeerInfo(data []byte) (*net.UDPAddr, error) {
+	s, err := readString(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return net.ResolveUDPAddr("udp", s)
+}
+
+// Rendezvous pairs hosts and joiners that registered the same invite code,
+// by its InviteID, and tells each the public endpoint the other's datagrams
+// came from, so both can punch through their NATs and talk directly. An
+// invite belongs to the first host registering it until that host stops
+// refreshing its registration. Lookups of unknown invites count as wrong
+// invite codes, with the same lockout as on a server.
+type Rendezvous struct {
+	Address string
+
+	conn      *net.UDPConn
+	guard     *guard
+	done      chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+	hosts     map[InviteID]hostRegistration
+}
+
+// hostRegistration is a host's observed endpoint and when it last registered.
+type hostRegistration struct {
+	addr     *net.UDPAddr
+	lastSeen time.Time
+}
+
+func NewRendezvous(address string) *Rendezvous {
+	return &Rendezvous{
+		Address: address,
+		guard:   newGuard(),
+		done:    make(chan struct{}),
+		hosts:   make(map[InviteID]hostRegistration),
+	}
+}
+
+// Start pairs hosts and joiners until the rendezvous server is closed, by
+// Close or by ctx being done.
+func (r *Rendezvous) Start(ctx context.Context) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", r.Address)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	r.conn = conn
+	stop := context.AfterFunc(ctx, func() { r.Close() })
+	defer stop()
+	go func() {
+		<-r.done
+		conn.Close()
+	}()
+	fmt.Println("Rendezvous listening on", r.Address)
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			if isClosed(r.done) {
+				return nil
+			}
+			fmt.Println("Error reading UDP:", err)
+			continue
+		}
+		msg, err := DecodeMessage(buf[:n])
+		if err != nil || msg.Type != MessageTypeRegister || !r.guard.admit(addr, msg.Type) {
+			continue
+		}
+		reg, err := DecodeRegistration(msg.Data)
+		if err != nil {
+			fmt.Println("Error decoding registration:", err)
+			continue
+		}
+		r.register(addr, reg)
+	}
+}
+
+// Close stops the rendezvous server and closes its socket.
+func (r *Rendezvous) Close() error {
+	r.closeOnce.Do(func() { close(r.done) })
+	return nil
+}
+
+// register records a host, or pairs a joiner with the host of its invite
+// code. Joiners keep registering until they are paired, so they aren't
+// remembered.
+func (r *Rendezvous) register(addr *net.UDPAddr, reg Registration) {
+	if reg.Role == PeerRoleJoiner && r.guard.lockedOut(addr) {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for id, h := range r.hosts {
+		if now.Sub(h.lastSeen) > hostRegistrationTTL {
+			delete(r.hosts, id)
+		}
+	}
+	switch reg.Role {
+	case PeerRoleHost:
+		h, ok := r.hosts[reg.Invite]
+		if ok && h.addr.String() != addr.String() {
+			// Someone else holds the invite.
+			return
+		}
+		if !ok {
+			fmt.Printf("Host %s registered\n", addr)
+		}
+		r.hosts[reg.Invite] = hostRegistration{addr: addr, lastSeen: now}
+	case PeerRoleJoiner:
+		h, ok := r.hosts[reg.Invite]
+		if !ok {
+			r.guard.lookupFailed(addr, reg.Invite)
+			return
+		}
+		fmt.Printf("Pairing joiner %s with host %s\n", addr, h.addr)
+		r.sendPeerInfo(h.addr, addr)
+		r.sendPeerInfo(addr, h.addr)
+	}
+}
+
+// sendPeerInfo sends the endpoint of peer to the address to.
+func (r *Rendezvous) sendPeerInfo(to, peer *net.UDPAddr) {
+	data, err := EncodePeerInfo(peer)
+	if err != nil {
+		fmt.Println("Error encoding peer info:", err)
+		return
+	}
+	encoded, _ := EncodeMessage(Message{Type: MessageTypePeerInfo, Data: data})
+	r.conn.WriteToUDP(encoded, to)
+}
+
+// registerLoop keeps the server registered as host of its invite code with
+// the rendezvous server at Server.Rendezvous.
+func (s *Server) registerLoop() {
+	addr, err := net.ResolveUDPAddr("udp", s.Rendezvous)
+	if err != nil {
+		fmt.Println("Error resolving rendezvous address:", err)
+		return
+	}
+	s.Lock.Lock()
+	s.rendezvousAddr = addr
+	s.Lock.Unlock()
+	for {
+		// The invite code may have been changed since the last time.
+		data, err := EncodeRegistration(Registration{Role: PeerRoleHost, Invite: NewInviteID(s.InviteCode())})
+		if err != nil {
+			fmt.Println("Error encoding registration:", err)
+			return
+		}
+		encoded, _ := EncodeMessage(Message{Type: MessageTypeRegister, Data: data})
+		if _, err := s.conn.WriteToUDP(encoded, addr); err != nil {
+			fmt.Println("Error registering with rendezvous:", err)
+		}
+		if !wait(s.done, registerInterval) {
+			return
+		}
+	}
+}
+
+// handlePeerInfo punches a hole towards the joiner announced by the
+// rendezvous server, so that the joiner's handshake gets through.
+func (s *Server) handlePeerInfo(from *net.UDPAddr, msg Message) {
+	s.Lock.Lock()
+	rendezvous := s.rendezvousAddr
+	s.Lock.Unlock()
+	if rendezvous == nil || !from.IP.Equal(rendezvous.IP) || from.Port != rendezvous.Port {
+		return
+	}
+	peer, err := DecodePeerInfo(msg.Data)
+	if err != nil {
+		fmt.Println("Error decoding peer info:", err)
+		return
+	}
+	encoded, _ := EncodeMessage(Message{Type: MessageTypePunch})
+	s.goLoop(func() {
+		for i := 0; i < punchCount; i++ {
+			s.conn.WriteToUDP(encoded, peer)
+			if !wait(s.done, punchInterval) {
+				return
+			}
+		}
+	})
+}
+
+// ConnectVia finds the host of inviteCode through the rendezvous server at
+// rendezvous and connects to it. The handshake is sent from the local port
+// the rendezvous server saw, so that it matches the NAT mapping the host
+// punched towards; its retransmissions punch our own NAT in turn. The
+// lookup goes through the client's Transport and Conditions as well. ctx
+// bounds the lookup and the handshake, as for Connect.
+func (c *Client) ConnectVia(ctx context.Context, rendezvous, inviteCode string) error {
+	dialed, err := c.Transport.Dial(nil, rendezvous)
+	if err != nil {
+		return err
+	}
+	conn := Condition(dialed, c.Conditions)
+	data, err := EncodeRegistration(Registration{Role: PeerRoleJoiner, Invite: NewInviteID(inviteCode)})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	encoded, _ := EncodeMessage(Message{Type: MessageTypeRegister, Data: data})
+	deadline := time.Now().Add(10 * time.Second)
+	if d, ok := ctx.Deadline(); ok {
+		deadline = minTime(deadline, d)
+	}
+	buf := make([]byte, maxDatagramSize)
+	var peer *net.UDPAddr
+	for peer == nil {
+		if err := ctx.Err(); err != nil {
+			conn.Close()
+			return err
+		}
+		if _, err := conn.Write(encoded); err != nil {
+			conn.Close()
+			return err
+		}
+		conn.SetReadDeadline(minTime(deadline, time.Now().Add(handshakeRetryInterval)))
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() || time.Now().After(deadline) {
+				conn.Close()
+				return fmt.Errorf("no host registered for invite code: %v", err)
+			}
+			continue
+		}
+		if msg, err := DecodeMessage(buf[:n]); err == nil && msg.Type == MessageTypePeerInfo {
+			if peer, err = DecodePeerInfo(msg.Data); err != nil {
+				conn.Close()
+				return fmt.Errorf("failed to decode peer info: %v", err)
+			}
+		}
+	}
+	if local, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		c.LocalAddr = local
+	}
+	conn.Close()
+	c.Address = peer.String()
+	return c.Connect(ctx, inviteCode)
+}
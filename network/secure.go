@@ -0,0 +1,262 @@
+package network
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// replayWindow is how many packets behind the newest one a sealed packet
+// may arrive and still be accepted, once.
+const replayWindow = 64
+
+// ErrUnauthenticated is returned for sealed datagrams that were forged,
+// tampered with or replayed.
+var ErrUnauthenticated = errors.New("unauthenticated datagram")
+
+// deriveSessionKey derives the key sealing one session's datagrams from the
+// invite code and both handshake nonces. Without an invite code the key
+// only keeps out peers that didn't see the handshake.
+func deriveSessionKey(inviteCode string, client, server Nonce) []byte {
+	mac := hmac.New(sha256.New, []byte(inviteCode))
+	mac.Write([]byte("pong-multiplayer session key"))
+	mac.Write(client[:])
+	mac.Write(server[:])
+	return mac.Sum(nil)
+}
+
+// inviteProof proves knowledge of the invite code in a handshake without
+// revealing it. It is bound to the client's nonce and to the cookie, which
+// only the client's address received from the server, so it can't be used
+// from elsewhere or once the cookie has expired.
+func inviteProof(inviteCode string, client Nonce, cookie Cookie) [32]byte {
+	mac := hmac.New(sha256.New, []byte(inviteCode))
+	mac.Write([]byte("pong-multiplayer invite proof"))
+	mac.Write(client[:])
+	mac.Write(cookie[:])
+	var proof [32]byte
+	copy(proof[:], mac.Sum(nil))
+	return proof
+}
+
+// sealInvite encrypts the invite code for the holder of the X25519 public
+// key. The result starts with the public half of a key pair made for this
+// message only, so the AES-GCM key derived from both is never reused.
+func sealInvite(inviteCode string, public []byte) ([]byte, error) {
+	peer, err := ecdh.X25519().NewPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+	own, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := inviteCipher(own, peer)
+	if err != nil {
+		return nil, err
+	}
+	sealed := own.PublicKey().Bytes()
+	return aead.Seal(sealed, make([]byte, aead.NonceSize()), []byte(inviteCode), nil), nil
+}
+
+// openInvite decrypts an invite code sealed for key with sealInvite.
+func openInvite(key *ecdh.PrivateKey, sealed []byte) (string, error) {
+	size := len(key.PublicKey().Bytes())
+	if len(sealed) < size {
+		return "", fmt.Errorf("%w: sealed invite code of %d bytes", ErrUnauthenticated, len(sealed))
+	}
+	peer, err := ecdh.X25519().NewPublicKey(sealed[:size])
+	if err != nil {
+		return "", err
+	}
+	aead, err := inviteCipher(key, peer)
+	if err != nil {
+		return "", err
+	}
+	code, err := aead.Open(nil, make([]byte, aead.NonceSize()), sealed[size:], nil)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	return string(code), nil
+}
+
+// inviteCipher returns the AES-GCM cipher for an invite code sealed between
+// the two keys.
+func inviteCipher(own *ecdh.PrivateKey, peer *ecdh.PublicKey) (cipher.AEAD, error) {
+	shared, err := own.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, shared)
+	mac.Write([]byte("pong-multiplayer sealed invite"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// secureChannel seals and opens the datagrams of one session after the
+// handshake. Every datagram is wrapped in a MessageTypeSecure message whose
+// Seq counts the sender's packets; the payload is AES-GCM sealed under the
+// session key, with the message header as additional data. Unless
+// encryption was negotiated, the inner message is sent in the clear and
+// only the tag is computed over it. Messages that would make a datagram
+// larger than mtu are sent in fragments, each sealed on its own.
+type secureChannel struct {
+	aead    cipher.AEAD
+	encrypt bool
+	mtu     int
+	// sendDir and recvDir keep the nonces of both directions apart.
+	sendDir, recvDir byte
+	write            func(data []byte) error
+	stats            *connStats
+
+	fragments reassembler
+
+	mu         sync.Mutex
+	sendSeq    uint32
+	recvMax    uint32
+	recvWindow uint64 // bit i set: recvMax-i has been received
+}
+
+// newSecureChannel creates the channel for one side of a session. write
+// transmits a sealed datagram of at most mtu bytes to the peer; the traffic
+// is counted in stats.
+func newSecureChannel(key []byte, encrypt, server bool, mtu int, stats *connStats, write func(data []byte) error) (*secureChannel, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c := &secureChannel{aead: aead, encrypt: encrypt, mtu: max(mtu, minMTU), write: write, stats: stats, sendDir: 0, recvDir: 1}
+	if server {
+		c.sendDir, c.recvDir = 1, 0
+	}
+	return c, nil
+}
+
+func (c *secureChannel) nonce(dir byte, seq uint32) []byte {
+	nonce := make([]byte, c.aead.NonceSize())
+	nonce[0] = dir
+	binary.BigEndian.PutUint32(nonce[len(nonce)-4:], seq)
+	return nonce
+}
+
+// header returns the additional data authenticated with each datagram.
+func header(seq uint32) []byte {
+	h := make([]byte, 5)
+	h[0] = byte(MessageTypeSecure)
+	binary.BigEndian.PutUint32(h[1:], seq)
+	return h
+}
+
+// send seals an encoded message and transmits it, in fragments if it
+// doesn't fit in one datagram.
+func (c *secureChannel) send(inner []byte) error {
+	size := c.mtu - messageHeaderSize - c.aead.Overhead()
+	if len(inner) <= size {
+		return c.seal(inner)
+	}
+	for _, f := range fragment(inner, size) {
+		if err := c.seal(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seal seals an encoded message into a single datagram and transmits it.
+func (c *secureChannel) seal(inner []byte) error {
+	c.mu.Lock()
+	c.sendSeq++
+	seq := c.sendSeq
+	c.mu.Unlock()
+
+	nonce, ad := c.nonce(c.sendDir, seq), header(seq)
+	var data []byte
+	if c.encrypt {
+		data = c.aead.Seal(nil, nonce, inner, ad)
+	} else {
+		tag := c.aead.Seal(nil, nonce, nil, append(ad, inner...))
+		data = append(append([]byte{}, inner...), tag...)
+	}
+	encoded, err := EncodeMessage(Message{Type: MessageTypeSecure, Seq: seq, Data: data})
+	if err != nil {
+		return err
+	}
+	c.stats.sent(len(encoded))
+	return c.write(encoded)
+}
+
+// open verifies a MessageTypeSecure message and returns the message it
+// carries. Each packet is accepted at most once.
+func (c *secureChannel) open(msg Message) (Message, error) {
+	overhead := c.aead.Overhead()
+	if len(msg.Data) < overhead {
+		return Message{}, fmt.Errorf("%w: %d bytes", ErrUnauthenticated, len(msg.Data))
+	}
+	nonce, ad := c.nonce(c.recvDir, msg.Seq), header(msg.Seq)
+	var inner []byte
+	var err error
+	if c.encrypt {
+		inner, err = c.aead.Open(nil, nonce, msg.Data, ad)
+	} else {
+		inner = msg.Data[:len(msg.Data)-overhead]
+		_, err = c.aead.Open(nil, nonce, msg.Data[len(msg.Data)-overhead:], append(ad, inner...))
+	}
+	if err != nil {
+		return Message{}, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	ok, skipped, late := c.accept(msg.Seq)
+	if !ok {
+		return Message{}, fmt.Errorf("%w: replayed packet %d", ErrUnauthenticated, msg.Seq)
+	}
+	c.stats.received(messageHeaderSize+len(msg.Data), skipped, late)
+	return DecodeMessage(inner)
+}
+
+// reassemble records a MessageTypeFragment message opened by open and
+// returns the message it completes, if any.
+func (c *secureChannel) reassemble(msg Message) (Message, bool) {
+	return c.fragments.add(msg, time.Now())
+}
+
+// accept records seq as received and reports whether it is new and not
+// too far behind the newest packet. It also returns how many packets were
+// skipped before seq, and whether seq arrived after a newer packet.
+func (c *secureChannel) accept(seq uint32) (ok bool, skipped uint32, late bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if seq == 0 {
+		return false, 0, false
+	}
+	if seq > c.recvMax {
+		shift := seq - c.recvMax
+		if shift < replayWindow {
+			c.recvWindow <<= shift
+		} else {
+			c.recvWindow = 0
+		}
+		c.recvWindow |= 1
+		c.recvMax = seq
+		return true, shift - 1, false
+	}
+	behind := c.recvMax - seq
+	if behind >= replayWindow || c.recvWindow&(1<<behind) != 0 {
+		return false, 0, false
+	}
+	c.recvWindow |= 1 << behind
+	return true, 0, true
+}
@@ -0,0 +1,177 @@
+package network
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// securePair returns the client and server ends of a session, each
+// recording the datagrams it writes.
+func securePair(t *testing.T, encrypt bool) (client, server *secureChannel, fromClient *[]Message) {
+	t.Helper()
+	key := deriveSessionKey("ABC123", Nonce{1}, Nonce{2})
+	var sent []Message
+	record := func(data []byte) error {
+		msg, err := DecodeMessage(data)
+		if err != nil {
+			return err
+		}
+		sent = append(sent, msg)
+		return nil
+	}
+	client, err := newSecureChannel(key, encrypt, false, maxDatagramSize, &connStats{}, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = newSecureChannel(key, encrypt, true, maxDatagramSize, &connStats{}, func([]byte) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server, &sent
+}
+
+// sealMessage seals msg on c and returns the datagram it wrote.
+func sealMessage(t *testing.T, c *secureChannel, sent *[]Message, msg Message) Message {
+	t.Helper()
+	inner, err := EncodeMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.send(inner); err != nil {
+		t.Fatal(err)
+	}
+	return (*sent)[len(*sent)-1]
+}
+
+func TestSecureRoundTrip(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		client, server, sent := securePair(t, encrypt)
+		msg := Message{Type: MessageTypeChat, Seq: 7, Data: []byte("hello")}
+		sealed := sealMessage(t, client, sent, msg)
+		if sealed.Type != MessageTypeSecure {
+			t.Errorf("encrypt=%v: sealed type %v, want secure", encrypt, sealed.Type)
+		}
+		got, err := server.open(sealed)
+		if err != nil {
+			t.Fatalf("encrypt=%v: open: %v", encrypt, err)
+		}
+		if got.Type != msg.Type || got.Seq != msg.Seq || string(got.Data) != "hello" {
+			t.Errorf("encrypt=%v: open = %+v, want %+v", encrypt, got, msg)
+		}
+		// The server's own direction uses other nonces, so a datagram can't
+		// be reflected back to the side that sent it.
+		if _, err := client.open(sealed); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("encrypt=%v: reflected datagram error = %v, want ErrUnauthenticated", encrypt, err)
+		}
+	}
+}
+
+func TestSecureRejects(t *testing.T) {
+	flip := func(msg Message, i int) Message {
+		msg.Data = append([]byte(nil), msg.Data...)
+		msg.Data[(len(msg.Data)+i)%len(msg.Data)] ^= 0x01
+		return msg
+	}
+	tests := []struct {
+		name string
+		// tamper returns the datagram to deliver, given the client's
+		// datagrams so far: the first was held back until it fell out of
+		// the replay window, the last has not been delivered yet and all
+		// others have been opened.
+		tamper func(sent []Message) Message
+	}{
+		{"duplicate seq", func(sent []Message) Message { return sent[len(sent)-2] }},
+		{"seq out of the window", func(sent []Message) Message { return sent[0] }},
+		{"flipped ciphertext byte", func(sent []Message) Message { return flip(sent[len(sent)-1], 0) }},
+		{"flipped tag byte", func(sent []Message) Message { return flip(sent[len(sent)-1], -1) }},
+		{"other seq", func(sent []Message) Message {
+			msg := sent[len(sent)-1]
+			msg.Seq++
+			return msg
+		}},
+		{"truncated", func(sent []Message) Message {
+			msg := sent[len(sent)-1]
+			msg.Data = msg.Data[:4]
+			return msg
+		}},
+	}
+	for _, encrypt := range []bool{false, true} {
+		for _, tt := range tests {
+			client, server, sent := securePair(t, encrypt)
+			for i := range replayWindow + 2 {
+				msg := sealMessage(t, client, sent, Message{Type: MessageTypeInputUpdate, Seq: uint32(i)})
+				if i == 0 || i == replayWindow+1 {
+					continue
+				}
+				if _, err := server.open(msg); err != nil {
+					t.Fatalf("encrypt=%v %s: open datagram %d: %v", encrypt, tt.name, i, err)
+				}
+			}
+			if _, err := server.open(tt.tamper(*sent)); !errors.Is(err, ErrUnauthenticated) {
+				t.Errorf("encrypt=%v %s: open error = %v, want ErrUnauthenticated", encrypt, tt.name, err)
+			}
+			// The tampering doesn't burn the sequence number of the
+			// genuine datagram.
+			if _, err := server.open((*sent)[len(*sent)-1]); err != nil {
+				t.Errorf("encrypt=%v %s: open genuine datagram after tampering: %v", encrypt, tt.name, err)
+			}
+		}
+	}
+}
+
+func TestSecureLateWithinWindow(t *testing.T) {
+	client, server, sent := securePair(t, false)
+	first := sealMessage(t, client, sent, Message{Type: MessageTypePing})
+	second := sealMessage(t, client, sent, Message{Type: MessageTypePing})
+	if _, err := server.open(second); err != nil {
+		t.Fatal(err)
+	}
+	// A packet overtaken by a newer one is still accepted, once.
+	if _, err := server.open(first); err != nil {
+		t.Errorf("late datagram: %v", err)
+	}
+	if _, err := server.open(first); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("late duplicate error = %v, want ErrUnauthenticated", err)
+	}
+}
+
+func TestInviteProof(t *testing.T) {
+	proof := inviteProof("ABC123", Nonce{1}, Cookie{2})
+	if proof != inviteProof("ABC123", Nonce{1}, Cookie{2}) {
+		t.Error("proof is not deterministic")
+	}
+	for name, other := range map[string][32]byte{
+		"code":   inviteProof("ABC124", Nonce{1}, Cookie{2}),
+		"nonce":  inviteProof("ABC123", Nonce{3}, Cookie{2}),
+		"cookie": inviteProof("ABC123", Nonce{1}, Cookie{3}),
+	} {
+		if other == proof {
+			t.Errorf("proof doesn't depend on the %s", name)
+		}
+	}
+}
+
+func TestSealInvite(t *testing.T) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := sealInvite("ABC123", key.PublicKey().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, err := openInvite(key, sealed); err != nil || code != "ABC123" {
+		t.Errorf("openInvite = %q, %v; want ABC123", code, err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := openInvite(key, sealed); err == nil {
+		t.Error("openInvite accepted a tampered invite")
+	}
+	other, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	sealed[len(sealed)-1] ^= 1
+	if _, err := openInvite(other, sealed); err == nil {
+		t.Error("openInvite opened an invite sealed for another key")
+	}
+}
@@ -1,93 +1,709 @@
 package network
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/binary"
 	"fmt"
 	"net"
 	"sync"
+	"time"
+
+	"pong-multiplayer/shared"
 )
 
+// playerSlots is the number of paddles; clients beyond that spectate.
+const playerSlots = 2
+
 type Server struct {
-	Address            string
+	Address string
+	// ExpectedInviteCode is the code clients must present. Once the server
+	// has started, change it with SetInviteCode only.
 	ExpectedInviteCode string
-	Clients            map[string]*net.UDPAddr
-	Lock               sync.Mutex
+	// RequiredCapabilities lists the capabilities a client must announce
+	// in its handshake to be accepted.
+	RequiredCapabilities Capability
+	Clients              map[string]*net.UDPAddr
+	Lock                 sync.Mutex
 	// InputUpdate is called when the server receives an input_update message.
-	InputUpdate func(msg Message)
-	conn        *net.UDPConn
+	InputUpdate func(addr *net.UDPAddr, msg Message)
+	// OnMessage is called for message types without a handler registered
+	// with Handle, including those delivered over the reliable channel.
+	OnMessage func(addr *net.UDPAddr, msg Message)
+	handlers  handlerRegistry[ServerHandler]
+	conn      Conn
+	sessions  map[string]*session
+	// slots maps each paddle slot to the address of the client holding it;
+	// a slot freed by a leaving client is "" until the next join. Clients
+	// without a slot are spectators.
+	slots []string
+	// ClientTimeout is how long a client may stay silent before it is
+	// dropped. The client's pings act as its heartbeat.
+	ClientTimeout time.Duration
+	// ReconnectGrace is how long the slot of a timed-out client stays
+	// reserved for a handshake presenting its session token.
+	ReconnectGrace time.Duration
+	// reservations holds the slots of timed-out clients by session token.
+	reservations map[SessionToken]reservation
+	// OnClientJoined is called after a new client completes the handshake,
+	// including a client reclaiming its slot with a session token. The slot
+	// is -1 for spectators, here and in OnClientLeft.
+	OnClientJoined func(addr *net.UDPAddr, slot int)
+	// OnClientLeft is called after a client disconnects or times out.
+	OnClientLeft func(addr *net.UDPAddr, slot int, reason LeaveReason)
+	// OnChat is called for every chat message the server relays.
+	OnChat func(addr *net.UDPAddr, chat ChatMessage)
+
+	// Encrypt enables encryption of the datagrams of clients that support
+	// it. Datagrams are authenticated either way.
+	Encrypt bool
+	// MTU is the largest datagram sent to clients; larger messages are
+	// fragmented. NewServer sets DefaultMTU.
+	MTU int
+	// Transport opens the server's socket; NewServer sets UDPTransport.
+	Transport Transport
+	// Conditions simulate a bad network on the server's socket.
+	Conditions Conditions
+	// AnnounceName is the name under which the server announces itself on
+	// the LAN. Empty means no announcements.
+	AnnounceName string
+	// Rendezvous is the address of a rendezvous server to register the
+	// invite code with, so that joiners behind NAT can find this server.
+	// Empty means no rendezvous.
+	Rendezvous     string
+	rendezvousAddr *net.UDPAddr
+
+	// guard rate-limits sources and checks handshake cookies and invite
+	// codes; a lobby shares its guard with all rooms.
+	guard *guard
+
+	// done is closed by Close; loops counts the goroutines that stop then.
+	done      chan struct{}
+	closeOnce sync.Once
+	loops     sync.WaitGroup
+
+	// snapshotSeq and snapshots are only used by BroadcastState.
+	snapshotSeq uint32
+	snapshots   snapshotHistory
+}
+
+// session holds the server's per-client connection state.
+type session struct {
+	addr         *net.UDPAddr
+	reliable     *ReliableChannel
+	capabilities Capability
+	// ackedSnapshot is the newest snapshot the client has acknowledged,
+	// used as the baseline for delta snapshots.
+	ackedSnapshot uint32
+	// lastInputSeq is the newest input accepted from this client. The game
+	// applies it on its next step.
+	lastInputSeq uint32
+	// lastSeen is when the last datagram from this client arrived.
+	lastSeen time.Time
+	// token identifies the session across reconnects.
+	token SessionToken
+	// secure seals every datagram after the handshake with the key derived
+	// from both nonces.
+	secure                   *secureChannel
+	clientNonce, serverNonce Nonce
+	stats                    *connStats
+	// chat limits the rate of the client's chat messages.
+	chat tokenBucket
+	// local is set for a client in this process, such as the host's own
+	// player, which reaches the server over a MemoryNetwork.
+	local bool
 }
 
 func NewServer(address, inviteCode string) *Server {
-	return &Server{
-		Address:            address,
-		ExpectedInviteCode: inviteCode,
-		Clients:            make(map[string]*net.UDPAddr),
+	s := &Server{
+		Address:              address,
+		ExpectedInviteCode:   inviteCode,
+		RequiredCapabilities: CapabilitySnapshotV1,
+		Clients:              make(map[string]*net.UDPAddr),
+		sessions:             make(map[string]*session),
+		ClientTimeout:        defaultClientTimeout,
+		ReconnectGrace:       defaultReconnectGrace,
+		reservations:         make(map[SessionToken]reservation),
+		done:                 make(chan struct{}),
+		guard:                newGuard(),
+		Transport:            UDPTransport{},
+		MTU:                  DefaultMTU,
 	}
+	s.registerHandlers()
+	return s
 }
 
-func (s *Server) Start() error {
-	udpAddr, err := net.ResolveUDPAddr("udp", s.Address)
-	if err != nil {
-		return err
-	}
-	conn, err := net.ListenUDP("udp", udpAddr)
+// Start listens and serves clients until the server is closed, by Close or
+// by ctx being done. It returns once all of the server's goroutines have
+// stopped.
+func (s *Server) Start(ctx context.Context) error {
+	conn, err := s.Transport.Listen(s.Address)
 	if err != nil {
 		return err
 	}
-	s.conn = conn
+	s.attach(Condition(conn, s.Conditions))
+	stop := context.AfterFunc(ctx, func() { s.Close() })
+	defer stop()
 	fmt.Println("Server listening on", s.Address)
-	buf := make([]byte, 1024)
+	if s.Rendezvous != "" {
+		s.goLoop(s.registerLoop)
+	}
+	if s.AnnounceName != "" {
+		s.goLoop(s.announceLoop)
+	}
+	s.Serve(s.conn)
+	s.loops.Wait()
+	fmt.Println("Server on", s.Address, "closed")
+	return nil
+}
+
+// Serve handles the clients that reach the server through conn, in addition
+// to those on the socket Start listens on, until the server is closed. It
+// closes conn then. The host lets its own player in this way, over a
+// MemoryNetwork, and may do so before calling Start to make sure that
+// player gets the left paddle.
+func (s *Server) Serve(conn Conn) error {
+	s.loops.Add(1)
+	defer s.loops.Done()
+	// Closing the socket ends the read loop.
+	s.goLoop(func() {
+		<-s.done
+		conn.Close()
+	})
+	// Clients in this process are neither rate limited nor banned.
+	local := isLocal(conn)
+	buf := make([]byte, maxDatagramSize)
 	for {
-		n, addr, err := s.conn.ReadFromUDP(buf)
+		n, addr, err := conn.ReadFromUDP(buf)
 		if err != nil {
+			if isClosed(s.done) {
+				return nil
+			}
 			fmt.Println("Error reading UDP:", err)
 			continue
 		}
 		data := make([]byte, n)
 		copy(data, buf[:n])
 		msg, err := DecodeMessage(data)
-		if err != nil {
-			fmt.Println("Error decoding message:", err)
+		if err != nil || (!local && !s.guard.admit(addr, msg.Type)) {
 			continue
 		}
-		switch msg.Type {
-		case MessageTypeHandshake:
-			// Validate the invite code.
-			if string(msg.Data) != s.ExpectedInviteCode {
-				errMsg := Message{Type: MessageTypeError, Data: []byte("Invalid invite code")}
-				encoded, _ := EncodeMessage(errMsg)
-				s.conn.WriteToUDP(encoded, addr)
-				continue
+		s.handleDatagram(conn, addr, msg)
+	}
+}
+
+// attach makes s send through conn and starts its background loops.
+func (s *Server) attach(conn Conn) {
+	s.conn = conn
+	s.goLoop(s.resendLoop)
+	s.goLoop(s.livenessLoop)
+}
+
+// goLoop runs loop in a goroutine that Start and Close wait for.
+func (s *Server) goLoop(loop func()) {
+	s.loops.Add(1)
+	go func() {
+		defer s.loops.Done()
+		loop()
+	}()
+}
+
+// Close stops the server: Start and Serve return, the background loops stop
+// and the sockets are closed, except one belonging to a Lobby. It returns
+// once all of that has happened, so it must not be called from the
+// server's callbacks. Clients are not told; they time out.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	s.loops.Wait()
+	return nil
+}
+
+// Done returns a channel that is closed once the server is closed, by
+// Close, its context or the Lobby hosting it as a room.
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// idle reports whether s has no clients and no reserved slots left.
+func (s *Server) idle() bool {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+	return len(s.sessions) == 0 && len(s.reservations) == 0
+}
+
+// hasSession reports whether the client at key completed the handshake.
+func (s *Server) hasSession(key string) bool {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+	_, ok := s.sessions[key]
+	return ok
+}
+
+// handleDatagram handles a message as it arrives from the network. Apart
+// from handshakes and NAT traversal, only messages sealed with the sender's
+// session key are accepted.
+func (s *Server) handleDatagram(conn Conn, addr *net.UDPAddr, msg Message) {
+	switch msg.Type {
+	case MessageTypeHandshake:
+		s.handleHandshake(conn, addr, msg)
+	case MessageTypePeerInfo:
+		s.handlePeerInfo(addr, msg)
+	case MessageTypePunch:
+		// Only there to open the joiner's NAT mapping.
+	case MessageTypeSecure:
+		s.Lock.Lock()
+		sess, ok := s.sessions[addr.String()]
+		s.Lock.Unlock()
+		if !ok {
+			return
+		}
+		inner, err := sess.secure.open(msg)
+		if err != nil {
+			s.guard.count(func(st *RejectStats) { st.Unauthenticated++ })
+			return
+		}
+		s.handleMessage(addr, inner)
+	}
+}
+
+// handleMessage dispatches an authenticated message from the client at addr
+// to the handler registered for its type.
+func (s *Server) handleMessage(addr *net.UDPAddr, msg Message) {
+	s.touch(addr)
+	if h, ok := s.handlers.get(msg.Type); ok {
+		h(addr, msg)
+		return
+	}
+	// Other messages are left to the application.
+	if s.OnMessage != nil {
+		s.OnMessage(addr, msg)
+	}
+}
+
+// registerHandlers registers the handlers of the message types the server
+// handles itself.
+func (s *Server) registerHandlers() {
+	s.Handle(MessageTypeDisconnect, s.handleDisconnect)
+	s.Handle(MessageTypeInputUpdate, s.handleInput)
+	s.Handle(MessageTypePing, s.handlePing)
+	s.Handle(MessageTypePong, s.handlePong)
+	s.Handle(MessageTypeFragment, s.handleFragment)
+	s.Handle(MessageTypeReliable, s.handleReliable)
+	s.Handle(MessageTypeAck, s.handleAck)
+	s.Handle(MessageTypeSnapshotAck, s.handleSnapshotAck)
+	s.Handle(MessageTypeChat, s.handleChat)
+}
+
+func (s *Server) handleDisconnect(addr *net.UDPAddr, msg Message) {
+	s.removeClient(addr.String(), LeaveReasonDisconnect)
+}
+
+// handleInput passes fresh input on to InputUpdate. Each input is applied
+// at most once and in order; stale or duplicated inputs from unknown or
+// reordered datagrams are dropped, and so is all input from spectators.
+func (s *Server) handleInput(addr *net.UDPAddr, msg Message) {
+	s.Lock.Lock()
+	sess, ok := s.sessions[addr.String()]
+	fresh := ok && msg.Seq > sess.lastInputSeq && s.clientIndexLocked(addr.String()) >= 0
+	if fresh {
+		sess.lastInputSeq = msg.Seq
+	} else if ok && msg.Seq <= sess.lastInputSeq {
+		sess.stats.outOfOrder()
+	}
+	s.Lock.Unlock()
+	if fresh && s.InputUpdate != nil {
+		s.InputUpdate(addr, msg)
+	}
+}
+
+// handlePing immediately responds with a Pong echoing the timestamp that
+// the client sent, followed by ours for clock synchronization.
+func (s *Server) handlePing(addr *net.UDPAddr, msg Message) {
+	buf := bytes.NewBuffer(append([]byte(nil), msg.Data...))
+	binary.Write(buf, binary.BigEndian, time.Now().UnixNano())
+	pong := Message{
+		Type: MessageTypePong,
+		Seq:  msg.Seq,
+		Data: buf.Bytes(),
+	}
+	encoded, _ := EncodeMessage(pong)
+	s.write(addr, encoded)
+}
+
+// handlePong handles the answer to one of our pings, carrying the time it
+// was sent.
+func (s *Server) handlePong(addr *net.UDPAddr, msg Message) {
+	var sentTime int64
+	if err := binary.Read(bytes.NewReader(msg.Data), binary.BigEndian, &sentTime); err == nil {
+		s.Lock.Lock()
+		if sess, ok := s.sessions[addr.String()]; ok {
+			sess.stats.addRTT(time.Duration(time.Now().UnixNano() - sentTime))
+		}
+		s.Lock.Unlock()
+	}
+}
+
+func (s *Server) handleFragment(addr *net.UDPAddr, msg Message) {
+	s.Lock.Lock()
+	sess, ok := s.sessions[addr.String()]
+	s.Lock.Unlock()
+	if !ok {
+		return
+	}
+	if whole, ok := sess.secure.reassemble(msg); ok {
+		s.handleMessage(addr, whole)
+	}
+}
+
+func (s *Server) handleReliable(addr *net.UDPAddr, msg Message) {
+	// Only clients that completed the handshake have a reliable channel.
+	if ch := s.reliableChannel(addr); ch != nil {
+		for _, m := range ch.Receive(msg) {
+			s.handleMessage(addr, m)
+		}
+	}
+}
+
+func (s *Server) handleAck(addr *net.UDPAddr, msg Message) {
+	if ch := s.reliableChannel(addr); ch != nil {
+		ch.HandleAck(msg)
+	}
+}
+
+func (s *Server) handleSnapshotAck(addr *net.UDPAddr, msg Message) {
+	s.Lock.Lock()
+	if sess, ok := s.sessions[addr.String()]; ok && msg.Seq > sess.ackedSnapshot {
+		sess.ackedSnapshot = msg.Seq
+	}
+	s.Lock.Unlock()
+}
+
+// handleHandshake answers a handshake that arrived on conn; the session
+// talks through conn from then on.
+func (s *Server) handleHandshake(conn Conn, addr *net.UDPAddr, msg Message) {
+	hs, ok := readHandshake(conn, addr, msg)
+	if !ok {
+		return
+	}
+	// Nothing larger than the handshake is sent in reply, except a small
+	// cookie challenge, until it proves that it comes from its source
+	// address.
+	if !s.guard.checkCookie(addr, hs.Cookie) {
+		writeCookie(conn, addr, s.guard.currentCookie(addr), nil)
+		return
+	}
+	if s.guard.lockedOut(addr) {
+		writeError(conn, addr, &ProtocolError{Reason: ErrorReasonLockedOut})
+		return
+	}
+	if missing := s.RequiredCapabilities &^ hs.Capabilities; missing != 0 {
+		writeError(conn, addr, &ProtocolError{
+			Reason: ErrorReasonMissingCapability,
+			Detail: fmt.Sprintf("client build %q lacks capabilities %#x", hs.BuildID, uint32(missing)),
+		})
+		return
+	}
+	// Validate the proof of the invite code. The code is read once, as an
+	// admin may change it meanwhile.
+	inviteCode := s.InviteCode()
+	if proof := inviteProof(inviteCode, hs.Nonce, hs.Cookie); !hmac.Equal(hs.InviteProof[:], proof[:]) {
+		s.guard.inviteFailed(addr)
+		writeError(conn, addr, &ProtocolError{Reason: ErrorReasonInvalidInviteCode})
+		return
+	}
+	// Add client address, keeping its original slot on a repeated handshake.
+	// A new address presenting a session token takes over that session's
+	// slot, and a new nonce from a known address rekeys its session.
+	s.Lock.Lock()
+	key := addr.String()
+	caps := hs.Capabilities & SupportedCapabilities
+	if !s.Encrypt {
+		caps &^= CapabilityEncryption
+	}
+	sess, known := s.sessions[key]
+	if !known || sess.clientNonce != hs.Nonce {
+		var token SessionToken
+		var err error
+		reclaimed := known
+		if known {
+			token = sess.token
+		} else if reclaimed = s.reclaimSlotLocked(key, hs.SessionToken); reclaimed {
+			token = hs.SessionToken
+		} else if token, err = newSessionToken(); err == nil {
+			s.assignSlotLocked(key)
+		}
+		if err == nil {
+			sess, err = s.newSession(conn, addr, caps, token, hs.Nonce, inviteCode)
+		}
+		if err != nil {
+			if !known {
+				s.freeSlotLocked(key)
 			}
-			// Send back handshake success.
-			successMsg := Message{Type: MessageTypeHandshakeSuccess, Data: []byte("OK")}
-			encoded, _ := EncodeMessage(successMsg)
-			s.conn.WriteToUDP(encoded, addr)
-			// Add client address.
-			s.Lock.Lock()
-			s.Clients[addr.String()] = addr
 			s.Lock.Unlock()
-		case MessageTypeInputUpdate:
-			if s.InputUpdate != nil {
-				s.InputUpdate(msg)
-			}
-		case MessageTypePing:
-			// Immediately respond with a Pong echoing the ping payload.
-			pong := Message{
-				Type: MessageTypePong,
-				Seq:  msg.Seq,
-				Data: msg.Data, // echoing the timestamp that the client sent
-			}
-			encoded, _ := EncodeMessage(pong)
-			s.conn.WriteToUDP(encoded, addr)
-		default:
-			// Other messages can be handled as needed.
+			fmt.Println("Error creating session:", err)
+			return
+		}
+		s.sessions[key] = sess
+		role := roleForSlot(s.clientIndexLocked(key))
+		if reclaimed {
+			fmt.Printf("Client %s reconnected (build %s) as %s\n", key, hs.BuildID, role)
+		} else {
+			fmt.Printf("Client %s joined (build %s) as %s\n", key, hs.BuildID, role)
 		}
 	}
-	// (Unreachable)
-	// return nil
+	s.Clients[key] = addr
+	slot := s.clientIndexLocked(key)
+	s.Lock.Unlock()
+	// Send back handshake success with the negotiated capabilities.
+	reply, _ := EncodeHandshakeReply(HandshakeReply{
+		ProtocolVersion: ProtocolVersion,
+		Capabilities:    caps,
+		Role:            roleForSlot(slot),
+		SessionToken:    sess.token,
+		Nonce:           sess.serverNonce,
+	})
+	successMsg := Message{Type: MessageTypeHandshakeSuccess, Data: reply}
+	encoded, _ := EncodeMessage(successMsg)
+	conn.WriteToUDP(encoded, addr)
+	if !known {
+		if s.OnClientJoined != nil {
+			s.OnClientJoined(addr, slot)
+		}
+		s.broadcastSpectators()
+	}
+}
+
+// newSession creates the state of a session whose handshake carried
+// clientNonce and proved inviteCode. A fresh session has no acknowledged
+// snapshot, so a reconnecting client starts from a full snapshot.
+func (s *Server) newSession(conn Conn, addr *net.UDPAddr, caps Capability, token SessionToken, clientNonce Nonce, inviteCode string) (*session, error) {
+	serverNonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+	key := deriveSessionKey(inviteCode, clientNonce, serverNonce)
+	stats := &connStats{}
+	secure, err := newSecureChannel(key, caps.Has(CapabilityEncryption), true, s.MTU, stats, func(data []byte) error {
+		_, err := conn.WriteToUDP(data, addr)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &session{
+		addr:         addr,
+		secure:       secure,
+		reliable:     NewReliableChannel(secure.send),
+		capabilities: caps,
+		lastSeen:     time.Now(),
+		token:        token,
+		clientNonce:  clientNonce,
+		serverNonce:  serverNonce,
+		stats:        stats,
+		local:        isLocal(conn),
+	}, nil
+}
+
+// write seals an encoded message for the client at addr and sends it.
+func (s *Server) write(addr *net.UDPAddr, data []byte) error {
+	s.Lock.Lock()
+	sess, ok := s.sessions[addr.String()]
+	s.Lock.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown client %s", addr)
+	}
+	return sess.secure.send(data)
+}
+
+// reliableChannel returns the reliable channel of the client at addr,
+// or nil if it hasn't completed the handshake.
+func (s *Server) reliableChannel(addr *net.UDPAddr) *ReliableChannel {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+	if sess, ok := s.sessions[addr.String()]; ok {
+		return sess.reliable
+	}
+	return nil
+}
+
+// reliableChannels returns a snapshot of every client's reliable channel.
+func (s *Server) reliableChannels() []*ReliableChannel {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+	channels := make([]*ReliableChannel, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		channels = append(channels, sess.reliable)
+	}
+	return channels
+}
+
+// resendLoop periodically retransmits unacknowledged reliable messages.
+func (s *Server) resendLoop() {
+	for wait(s.done, reliableResendInterval/2) {
+		now := time.Now()
+		for _, ch := range s.reliableChannels() {
+			ch.Resend(now)
+		}
+	}
+}
+
+// ClientIndex returns the slot of the client at addr (0 = left paddle,
+// 1 = right paddle), or -1 if the address is unknown or a spectator.
+func (s *Server) ClientIndex(addr *net.UDPAddr) int {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+	return s.clientIndexLocked(addr.String())
+}
+
+func (s *Server) clientIndexLocked(key string) int {
+	for i, k := range s.slots {
+		if k == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// assignSlotLocked gives key the lowest free slot that isn't reserved
+// for a reconnecting client. If every slot is taken, key spectates.
+func (s *Server) assignSlotLocked(key string) {
+	reserved := make(map[int]bool, len(s.reservations))
+	for _, r := range s.reservations {
+		reserved[r.slot] = true
+	}
+	for i, k := range s.slots {
+		if k == "" && !reserved[i] {
+			s.slots[i] = key
+			return
+		}
+	}
+	if len(s.slots) < playerSlots {
+		s.slots = append(s.slots, key)
+	}
+}
+
+// freeSlotLocked releases the slot held by key, if any.
+func (s *Server) freeSlotLocked(key string) {
+	if i := s.clientIndexLocked(key); i >= 0 {
+		s.slots[i] = ""
+	}
+}
+
+// PlayerCount returns the number of clients holding a paddle.
+func (s *Server) PlayerCount() int {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+	n := 0
+	for _, k := range s.slots {
+		if k != "" {
+			n++
+		}
+	}
+	return n
 }
 
+// SpectatorCount returns the number of connected clients without a paddle.
+func (s *Server) SpectatorCount() int {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+	n := 0
+	for key := range s.sessions {
+		if s.clientIndexLocked(key) < 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// broadcastSpectators tells every client the current spectator count.
+func (s *Server) broadcastSpectators() {
+	s.Broadcast(Message{Type: MessageTypeSpectators, Data: EncodeSpectators(s.SpectatorCount())})
+}
+
+// readHandshake decodes a handshake that arrived on conn. The protocol
+// version is checked first, as the rest of the layout depends on it:
+// clients speaking another version, or sending a handshake that doesn't
+// decode, are told so with an error no larger than their datagram.
+func readHandshake(conn Conn, addr *net.UDPAddr, msg Message) (Handshake, bool) {
+	size := messageHeaderSize + len(msg.Data)
+	if len(msg.Data) >= 2 {
+		if v := binary.BigEndian.Uint16(msg.Data); v != ProtocolVersion {
+			writeSmallError(conn, addr, size, &ProtocolError{
+				Reason: ErrorReasonVersionMismatch,
+				Detail: fmt.Sprintf("server speaks v%d, client v%d", ProtocolVersion, v),
+			})
+			return Handshake{}, false
+		}
+	}
+	hs, err := DecodeHandshake(msg.Data)
+	if err != nil {
+		writeSmallError(conn, addr, size, &ProtocolError{Reason: ErrorReasonMalformed})
+		return Handshake{}, false
+	}
+	return hs, true
+}
+
+// writeSmallError replies to addr with e, dropping its detail if the reply
+// would be larger than size bytes, and not at all if it still would be.
+func writeSmallError(conn Conn, addr *net.UDPAddr, size int, e *ProtocolError) {
+	data, err := EncodeError(e)
+	if err == nil && messageHeaderSize+len(data) > size {
+		data, err = EncodeError(&ProtocolError{Reason: e.Reason})
+	}
+	if err != nil || messageHeaderSize+len(data) > size {
+		return
+	}
+	encoded, _ := EncodeMessage(Message{Type: MessageTypeError, Data: data})
+	conn.WriteToUDP(encoded, addr)
+}
+
+// writeCookie challenges addr to repeat its handshake with cookie c. A
+// lobby appends the X25519 public key to seal the invite code for.
+func writeCookie(conn Conn, addr *net.UDPAddr, c Cookie, inviteKey []byte) {
+	encoded, _ := EncodeMessage(Message{Type: MessageTypeCookie, Data: append(c[:], inviteKey...)})
+	conn.WriteToUDP(encoded, addr)
+}
+
+// writeError replies to addr with a structured MessageTypeError.
+func writeError(conn Conn, addr *net.UDPAddr, e *ProtocolError) {
+	data, err := EncodeError(e)
+	if err != nil {
+		fmt.Println("Error encoding error message:", err)
+		return
+	}
+	encoded, _ := EncodeMessage(Message{Type: MessageTypeError, Data: data})
+	conn.WriteToUDP(encoded, addr)
+}
+
+// Send transmits msg to a single client, using its reliable channel if the
+// message type is listed in ReliableTypes.
+func (s *Server) Send(addr *net.UDPAddr, msg Message) error {
+	if ReliableTypes[msg.Type] {
+		ch := s.reliableChannel(addr)
+		if ch == nil {
+			return fmt.Errorf("unknown client %s", addr)
+		}
+		return ch.Send(msg)
+	}
+	data, err := EncodeMessage(msg)
+	if err != nil {
+		return err
+	}
+	return s.write(addr, data)
+}
+
+// Broadcast transmits msg to every connected client, reliably if its type
+// is listed in ReliableTypes.
 func (s *Server) Broadcast(msg Message) {
+	if ReliableTypes[msg.Type] {
+		for _, ch := range s.reliableChannels() {
+			if err := ch.Send(msg); err != nil {
+				fmt.Println("Error sending reliable message:", err)
+			}
+		}
+		return
+	}
 	data, err := EncodeMessage(msg)
 	if err != nil {
 		fmt.Println("Error encoding message:", err)
@@ -95,7 +711,49 @@ func (s *Server) Broadcast(msg Message) {
 	}
 	s.Lock.Lock()
 	defer s.Lock.Unlock()
-	for _, addr := range s.Clients {
-		s.conn.WriteToUDP(data, addr)
+	for _, sess := range s.sessions {
+		sess.secure.send(data)
+	}
+}
+
+// BroadcastState sends a state snapshot to every connected client, stamped
+// with the last input the game applied to that client's paddle, as listed
+// in state.InputSeqs. Clients that
+// support delta snapshots and have acknowledged a snapshot that is still in
+// the history receive only the fields that changed since then; everyone
+// else, including clients that stopped acknowledging because of loss,
+// receives the full snapshot.
+func (s *Server) BroadcastState(state shared.State) {
+	s.snapshotSeq++
+	seq := s.snapshotSeq
+	// The history holds the shared state only; InputSeq differs per client
+	// and is simply sent whenever it is non-zero.
+	inputSeqs := state.InputSeqs
+	state.InputSeq, state.InputSeqs = 0, [2]uint32{}
+	s.snapshots.put(seq, state)
+
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+	for key, sess := range s.sessions {
+		clientState := state
+		if slot := s.clientIndexLocked(key); slot >= 0 && slot < len(inputSeqs) {
+			clientState.InputSeq = inputSeqs[slot]
+		}
+		msg := Message{Type: MessageTypeStateUpdate, Seq: seq}
+		if base, ok := s.snapshots.get(sess.ackedSnapshot); ok && sess.capabilities.Has(CapabilityDeltaSnapshots) {
+			if delta, err := EncodeStateDelta(base, sess.ackedSnapshot, clientState); err == nil {
+				msg = Message{Type: MessageTypeStateDelta, Seq: seq, Data: delta}
+			}
+		}
+		if msg.Data == nil {
+			full, err := EncodeState(clientState)
+			if err != nil {
+				fmt.Println("Error encoding state:", err)
+				continue
+			}
+			msg.Data = full
+		}
+		data, _ := EncodeMessage(msg)
+		sess.secure.send(data)
 	}
 }
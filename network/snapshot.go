@@ -0,0 +1,257 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"pong-multiplayer/shared"
+)
+
+// SnapshotVersion is the schema version written as the first byte of every
+// encoded state snapshot.
+//
+// Fields are only ever appended to the end of the schema, so a decoder reads
+// the fields it knows about and ignores any trailing bytes written by a newer
+// version. When adding a field, bump SnapshotVersion and only read the field
+// in DecodeState if the snapshot's version is new enough to contain it.
+//
+// Version 2 added InputSeq, version 3 added Tick.
+const SnapshotVersion uint8 = 3
+
+// snapshotHistorySize is how many recent snapshots are kept as possible
+// delta baselines, on both the server and the client.
+const snapshotHistorySize = 64
+
+// ErrSnapshotVersion is returned when a snapshot carries an invalid version.
+var ErrSnapshotVersion = errors.New("invalid snapshot version")
+
+// ErrUnknownBaseline is returned when a delta snapshot refers to a baseline
+// that is no longer (or was never) available.
+var ErrUnknownBaseline = errors.New("unknown snapshot baseline")
+
+// wireState is shared.State with fixed-size fields, in schema order.
+type wireState struct {
+	BallX, BallY          float32
+	BallVX, BallVY        float32
+	P1X, P1Y              float32
+	P2X, P2Y              float32
+	ScoreLeft, ScoreRight int32
+	Timestamp             int64
+	InputSeq              uint32
+	Tick                  uint64
+}
+
+func toWire(s shared.State) wireState {
+	return wireState{
+		BallX: s.BallX, BallY: s.BallY,
+		BallVX: s.BallVX, BallVY: s.BallVY,
+		P1X: s.P1X, P1Y: s.P1Y,
+		P2X: s.P2X, P2Y: s.P2Y,
+		ScoreLeft: int32(s.ScoreLeft), ScoreRight: int32(s.ScoreRight),
+		Timestamp: s.Timestamp,
+		InputSeq:  s.InputSeq,
+		Tick:      s.Tick,
+	}
+}
+
+func (w wireState) state() shared.State {
+	return shared.State{
+		BallX: w.BallX, BallY: w.BallY,
+		BallVX: w.BallVX, BallVY: w.BallVY,
+		P1X: w.P1X, P1Y: w.P1Y,
+		P2X: w.P2X, P2Y: w.P2Y,
+		ScoreLeft: int(w.ScoreLeft), ScoreRight: int(w.ScoreRight),
+		Timestamp: w.Timestamp,
+		InputSeq:  w.InputSeq,
+		Tick:      w.Tick,
+	}
+}
+
+// fields returns pointers to the fields present in the given schema version,
+// in schema order.
+func (w *wireState) fields(version uint8) []interface{} {
+	// Version 1 fields.
+	fields := []interface{}{
+		&w.BallX, &w.BallY,
+		&w.BallVX, &w.BallVY,
+		&w.P1X, &w.P1Y,
+		&w.P2X, &w.P2Y,
+		&w.ScoreLeft, &w.ScoreRight,
+		&w.Timestamp,
+	}
+	if version >= 2 {
+		fields = append(fields, &w.InputSeq)
+	}
+	if version >= 3 {
+		fields = append(fields, &w.Tick)
+	}
+	return fields
+}
+
+func readSnapshotVersion(buf *bytes.Reader) (uint8, error) {
+	var version uint8
+	if err := binary.Read(buf, binary.BigEndian, &version); err != nil {
+		return 0, err
+	}
+	if version == 0 {
+		return 0, fmt.Errorf("%w: %d", ErrSnapshotVersion, version)
+	}
+	return version, nil
+}
+
+// EncodeState produces the binary representation of a state snapshot:
+// 1 byte for the schema version followed by the fields in schema order.
+func EncodeState(s shared.State) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.BigEndian, SnapshotVersion); err != nil {
+		return nil, err
+	}
+	w := toWire(s)
+	for _, f := range w.fields(SnapshotVersion) {
+		if err := binary.Write(buf, binary.BigEndian, f); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeState converts a binary snapshot produced by EncodeState back into
+// a State.
+func DecodeState(data []byte) (shared.State, error) {
+	buf := bytes.NewReader(data)
+	version, err := readSnapshotVersion(buf)
+	if err != nil {
+		return shared.State{}, err
+	}
+	var w wireState
+	for _, f := range w.fields(min(version, SnapshotVersion)) {
+		if err := binary.Read(buf, binary.BigEndian, f); err != nil {
+			return shared.State{}, err
+		}
+	}
+	return w.state(), nil
+}
+
+// EncodeStateDelta produces the binary representation of s relative to
+// the baseline snapshot base, which the receiver knows as baseSeq:
+// 1 byte for the schema version, 4 bytes for baseSeq, 2 bytes for a mask
+// of the fields that differ from base, then only those fields in schema
+// order.
+func EncodeStateDelta(base shared.State, baseSeq uint32, s shared.State) ([]byte, error) {
+	wb, ws := toWire(base), toWire(s)
+	baseFields, fields := wb.fields(SnapshotVersion), ws.fields(SnapshotVersion)
+
+	var mask uint16
+	changed := new(bytes.Buffer)
+	for i := range fields {
+		a, b := new(bytes.Buffer), new(bytes.Buffer)
+		binary.Write(a, binary.BigEndian, baseFields[i])
+		if err := binary.Write(b, binary.BigEndian, fields[i]); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(a.Bytes(), b.Bytes()) {
+			mask |= 1 << i
+			changed.Write(b.Bytes())
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	for _, f := range []interface{}{SnapshotVersion, baseSeq, mask} {
+		if err := binary.Write(buf, binary.BigEndian, f); err != nil {
+			return nil, err
+		}
+	}
+	buf.Write(changed.Bytes())
+	return buf.Bytes(), nil
+}
+
+// DecodeStateDelta converts a delta snapshot produced by EncodeStateDelta
+// back into a State. baseline looks up a previously received snapshot by
+// its sequence number.
+func DecodeStateDelta(data []byte, baseline func(seq uint32) (shared.State, bool)) (shared.State, error) {
+	buf := bytes.NewReader(data)
+	version, err := readSnapshotVersion(buf)
+	if err != nil {
+		return shared.State{}, err
+	}
+	var baseSeq uint32
+	var mask uint16
+	if err := binary.Read(buf, binary.BigEndian, &baseSeq); err != nil {
+		return shared.State{}, err
+	}
+	if err := binary.Read(buf, binary.BigEndian, &mask); err != nil {
+		return shared.State{}, err
+	}
+	base, ok := baseline(baseSeq)
+	if !ok {
+		return shared.State{}, fmt.Errorf("%w: %d", ErrUnknownBaseline, baseSeq)
+	}
+	w := toWire(base)
+	for i, f := range w.fields(min(version, SnapshotVersion)) {
+		if mask&(1<<i) == 0 {
+			continue
+		}
+		if err := binary.Read(buf, binary.BigEndian, f); err != nil {
+			return shared.State{}, err
+		}
+	}
+	return w.state(), nil
+}
+
+// snapshotHistory is a ring buffer of recent snapshots keyed by sequence.
+type snapshotHistory struct {
+	seqs   [snapshotHistorySize]uint32
+	states [snapshotHistorySize]shared.State
+}
+
+func (h *snapshotHistory) put(seq uint32, s shared.State) {
+	i := seq % snapshotHistorySize
+	h.seqs[i] = seq
+	h.states[i] = s
+}
+
+func (h *snapshotHistory) get(seq uint32) (shared.State, bool) {
+	i := seq % snapshotHistorySize
+	if seq == 0 || h.seqs[i] != seq {
+		return shared.State{}, false
+	}
+	return h.states[i], true
+}
+
+// EncodeScore produces the payload of a MessageTypeScore message:
+// the left and right scores as 4 bytes each.
+func EncodeScore(left, right int) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint32(data[0:4], uint32(int32(left)))
+	binary.BigEndian.PutUint32(data[4:8], uint32(int32(right)))
+	return data
+}
+
+// EncodeSpectators produces the payload of a MessageTypeSpectators message:
+// the spectator count as 2 bytes.
+func EncodeSpectators(count int) []byte {
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, uint16(count))
+	return data
+}
+
+// DecodeSpectators converts a MessageTypeSpectators payload back into the
+// spectator count.
+func DecodeSpectators(data []byte) (int, error) {
+	if len(data) < 2 {
+		return 0, fmt.Errorf("spectators payload too short: %d bytes", len(data))
+	}
+	return int(binary.BigEndian.Uint16(data)), nil
+}
+
+// DecodeScore converts a MessageTypeScore payload back into both scores.
+func DecodeScore(data []byte) (left, right int, err error) {
+	if len(data) < 8 {
+		return 0, 0, fmt.Errorf("score payload too short: %d bytes", len(data))
+	}
+	left = int(int32(binary.BigEndian.Uint32(data[0:4])))
+	right = int(int32(binary.BigEndian.Uint32(data[4:8])))
+	return left, right, nil
+}
@@ -0,0 +1,148 @@
+package network
+
+import (
+	"errors"
+	"testing"
+
+	"pong-multiplayer/shared"
+)
+
+func testState() shared.State {
+	return shared.State{
+		BallX: 390, BallY: 290, BallVX: -250, BallVY: 250,
+		P1X: 30, P1Y: 250, P2X: 760, P2Y: 180,
+		ScoreLeft: 3, ScoreRight: 7,
+		Timestamp: 1700000000000000000,
+		InputSeq:  42,
+		Tick:      1234,
+	}
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		state shared.State
+	}{
+		{"zero", shared.State{}},
+		{"match", testState()},
+		{"negative scores", shared.State{ScoreLeft: -1, ScoreRight: -2, BallVX: -1.5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := EncodeState(tt.state)
+			if err != nil {
+				t.Fatalf("EncodeState: %v", err)
+			}
+			if data[0] != SnapshotVersion {
+				t.Errorf("version byte = %d, want %d", data[0], SnapshotVersion)
+			}
+			got, err := DecodeState(data)
+			if err != nil {
+				t.Fatalf("DecodeState: %v", err)
+			}
+			if got != tt.state {
+				t.Errorf("DecodeState = %+v, want %+v", got, tt.state)
+			}
+		})
+	}
+}
+
+func TestDecodeStateVersions(t *testing.T) {
+	data, err := EncodeState(testState())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A newer snapshot with a field appended decodes, ignoring the field.
+	newer := append([]byte{SnapshotVersion + 1}, data[1:]...)
+	newer = append(newer, 0xAA, 0xBB)
+	if got, err := DecodeState(newer); err != nil || got != testState() {
+		t.Errorf("DecodeState(newer) = %+v, %v; want %+v", got, err, testState())
+	}
+
+	// A version 1 snapshot lacks InputSeq and Tick.
+	old := append([]byte{1}, data[1:len(data)-12]...)
+	want := testState()
+	want.InputSeq, want.Tick = 0, 0
+	if got, err := DecodeState(old); err != nil || got != want {
+		t.Errorf("DecodeState(version 1) = %+v, %v; want %+v", got, err, want)
+	}
+
+	if _, err := DecodeState(append([]byte{0}, data[1:]...)); !errors.Is(err, ErrSnapshotVersion) {
+		t.Errorf("DecodeState(version 0) error = %v, want ErrSnapshotVersion", err)
+	}
+	if _, err := DecodeState(data[:10]); err == nil {
+		t.Error("DecodeState(truncated) succeeded")
+	}
+}
+
+func TestStateDelta(t *testing.T) {
+	base := testState()
+	moved := base
+	moved.BallX, moved.BallY = 400, 300
+	moved.Tick++
+	scored := moved
+	scored.ScoreRight++
+
+	tests := []struct {
+		name  string
+		state shared.State
+		// size is the encoded size: the 7-byte header plus changed fields.
+		size int
+	}{
+		{"unchanged", base, 7},
+		{"ball moved", moved, 7 + 4 + 4 + 8},
+		{"scored", scored, 7 + 4 + 4 + 4 + 8},
+	}
+	history := func(seq uint32) (shared.State, bool) {
+		return base, seq == 5
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := EncodeStateDelta(base, 5, tt.state)
+			if err != nil {
+				t.Fatalf("EncodeStateDelta: %v", err)
+			}
+			if len(data) != tt.size {
+				t.Errorf("delta is %d bytes, want %d", len(data), tt.size)
+			}
+			got, err := DecodeStateDelta(data, history)
+			if err != nil {
+				t.Fatalf("DecodeStateDelta: %v", err)
+			}
+			if got != tt.state {
+				t.Errorf("DecodeStateDelta = %+v, want %+v", got, tt.state)
+			}
+		})
+	}
+}
+
+func TestStateDeltaUnknownBaseline(t *testing.T) {
+	data, err := EncodeStateDelta(testState(), 9, testState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h snapshotHistory
+	h.put(9+snapshotHistorySize, testState())
+	if _, err := DecodeStateDelta(data, h.get); !errors.Is(err, ErrUnknownBaseline) {
+		t.Errorf("DecodeStateDelta error = %v, want ErrUnknownBaseline", err)
+	}
+}
+
+func TestSnapshotHistory(t *testing.T) {
+	var h snapshotHistory
+	if _, ok := h.get(0); ok {
+		t.Error("get(0) found a snapshot")
+	}
+	for seq := uint32(1); seq <= snapshotHistorySize+1; seq++ {
+		h.put(seq, shared.State{Tick: uint64(seq)})
+	}
+	if _, ok := h.get(1); ok {
+		t.Error("get(1) found a snapshot that was overwritten")
+	}
+	for _, seq := range []uint32{2, snapshotHistorySize + 1} {
+		if s, ok := h.get(seq); !ok || s.Tick != uint64(seq) {
+			t.Errorf("get(%d) = %+v, %v", seq, s, ok)
+		}
+	}
+}
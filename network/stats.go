@@ -0,0 +1,100 @@
+package network
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats describe the traffic of one connection.
+type Stats struct {
+	// RTT is the smoothed round trip time measured with pings.
+	RTT time.Duration
+	// Jitter is the mean deviation of the round trip time.
+	Jitter time.Duration
+	// PacketsIn counts the authenticated datagrams received.
+	PacketsIn uint64
+	// PacketsLost counts the datagrams inferred lost from gaps in their
+	// sequence numbers, less those that turned up late.
+	PacketsLost uint64
+	// OutOfOrder counts the messages dropped because a newer one of the
+	// same kind had already been processed.
+	OutOfOrder uint64
+	// BytesIn and BytesOut count the bytes of datagrams received and sent
+	// after the handshake.
+	BytesIn, BytesOut uint64
+}
+
+// Loss returns the fraction of datagrams lost on their way here.
+func (st Stats) Loss() float64 {
+	if total := st.PacketsIn + st.PacketsLost; total > 0 {
+		return float64(st.PacketsLost) / float64(total)
+	}
+	return 0
+}
+
+// connStats collects the Stats of one connection.
+type connStats struct {
+	mu    sync.Mutex
+	stats Stats
+}
+
+// addRTT feeds a round trip time into the smoothed RTT and jitter, as TCP
+// does for its retransmission timer.
+func (c *connStats) addRTT(rtt time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stats.RTT == 0 {
+		c.stats.RTT, c.stats.Jitter = rtt, rtt/2
+		return
+	}
+	c.stats.Jitter += ((c.stats.RTT - rtt).Abs() - c.stats.Jitter) / 4
+	c.stats.RTT += (rtt - c.stats.RTT) / 8
+}
+
+// received records an authenticated datagram of n bytes, skipped datagrams
+// before it, and whether it filled an earlier gap.
+func (c *connStats) received(n int, skipped uint32, late bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.PacketsIn++
+	c.stats.BytesIn += uint64(n)
+	c.stats.PacketsLost += uint64(skipped)
+	if late && c.stats.PacketsLost > 0 {
+		c.stats.PacketsLost--
+	}
+}
+
+func (c *connStats) sent(n int) {
+	c.mu.Lock()
+	c.stats.BytesOut += uint64(n)
+	c.mu.Unlock()
+}
+
+func (c *connStats) outOfOrder() {
+	c.mu.Lock()
+	c.stats.OutOfOrder++
+	c.mu.Unlock()
+}
+
+func (c *connStats) get() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Stats returns the statistics of the connection to the server, kept
+// across reconnects.
+func (c *Client) Stats() Stats {
+	return c.stats.get()
+}
+
+// ClientStats returns the statistics of every connected client, by address.
+func (s *Server) ClientStats() map[string]Stats {
+	s.Lock.Lock()
+	defer s.Lock.Unlock()
+	stats := make(map[string]Stats, len(s.sessions))
+	for key, sess := range s.sessions {
+		stats[key] = sess.stats.get()
+	}
+	return stats
+}
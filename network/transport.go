@@ -0,0 +1,242 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Conn is a datagram socket as clients and servers use it. *net.UDPConn
+// implements it, and so do the conns of a MemoryNetwork and those wrapped
+// by Condition.
+type Conn interface {
+	ReadFromUDP(b []byte) (int, *net.UDPAddr, error)
+	WriteToUDP(b []byte, addr *net.UDPAddr) (int, error)
+	Write(b []byte) (int, error)
+	SetReadDeadline(t time.Time) error
+	LocalAddr() net.Addr
+	Close() error
+}
+
+// Transport opens the conns that clients and servers exchange datagrams
+// over.
+type Transport interface {
+	// Listen opens a conn receiving datagrams sent to address.
+	Listen(address string) (Conn, error)
+	// Dial opens a conn exchanging datagrams with address, sending from
+	// local, or from an address of the transport's choosing if local is nil.
+	Dial(local *net.UDPAddr, address string) (Conn, error)
+}
+
+// UDPTransport carries datagrams over real UDP sockets. It is the default
+// Transport.
+type UDPTransport struct{}
+
+func (UDPTransport) Listen(address string) (Conn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenUDP("udp", udpAddr)
+}
+
+func (UDPTransport) Dial(local *net.UDPAddr, address string) (Conn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return net.DialUDP("udp", local, udpAddr)
+}
+
+// MemoryNetwork is a Transport that passes datagrams between conns in the
+// same process, in order and without loss unless Conditions are applied.
+// Addresses look like UDP addresses; listening on an address without an IP
+// listens on 127.0.0.1. Dialed conns get ports counting up from 1, which
+// real UDP never hands out as ephemeral ports, so their addresses don't
+// clash with those of real clients of the same server.
+type MemoryNetwork struct {
+	mu        sync.Mutex
+	endpoints map[string]*memoryConn
+	nextPort  int
+}
+
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{endpoints: make(map[string]*memoryConn)}
+}
+
+// resolveMemoryAddr parses address, defaulting its IP to 127.0.0.1.
+func resolveMemoryAddr(address string) (*net.UDPAddr, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	if addr.IP == nil || addr.IP.IsUnspecified() {
+		addr.IP = net.IPv4(127, 0, 0, 1)
+	}
+	return addr, nil
+}
+
+func (m *MemoryNetwork) Listen(address string) (Conn, error) {
+	addr, err := resolveMemoryAddr(address)
+	if err != nil {
+		return nil, err
+	}
+	return m.open(addr, nil)
+}
+
+func (m *MemoryNetwork) Dial(local *net.UDPAddr, address string) (Conn, error) {
+	remote, err := resolveMemoryAddr(address)
+	if err != nil {
+		return nil, err
+	}
+	if local == nil {
+		m.mu.Lock()
+		m.nextPort++
+		local = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: m.nextPort}
+		m.mu.Unlock()
+	}
+	return m.open(local, remote)
+}
+
+// open registers a conn at local.
+func (m *MemoryNetwork) open(local, remote *net.UDPAddr) (*memoryConn, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.endpoints[local.String()]; ok {
+		return nil, fmt.Errorf("memory network: address %s already in use", local)
+	}
+	c := &memoryConn{network: m, local: local, remote: remote, queue: newQueue()}
+	m.endpoints[local.String()] = c
+	return c, nil
+}
+
+// deliver queues data at the conn listening on to, if there is one.
+func (m *MemoryNetwork) deliver(from, to *net.UDPAddr, data []byte) {
+	m.mu.Lock()
+	c, ok := m.endpoints[to.String()]
+	m.mu.Unlock()
+	if ok {
+		c.push(datagram{data: append([]byte(nil), data...), addr: from})
+	}
+}
+
+// memoryConn is one endpoint of a MemoryNetwork.
+type memoryConn struct {
+	*queue
+	network       *MemoryNetwork
+	local, remote *net.UDPAddr
+}
+
+// isLocal reports whether conn is a conn of a MemoryNetwork, whose peers
+// run in this process.
+func isLocal(conn Conn) bool {
+	_, ok := conn.(*memoryConn)
+	return ok
+}
+
+func (c *memoryConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	if c.isClosed() {
+		return 0, net.ErrClosed
+	}
+	c.network.deliver(c.local, addr, b)
+	return len(b), nil
+}
+
+func (c *memoryConn) Write(b []byte) (int, error) {
+	if c.remote == nil {
+		return 0, fmt.Errorf("memory network: write on unconnected conn %s", c.local)
+	}
+	return c.WriteToUDP(b, c.remote)
+}
+
+func (c *memoryConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *memoryConn) Close() error {
+	c.network.mu.Lock()
+	if c.network.endpoints[c.local.String()] == c {
+		delete(c.network.endpoints, c.local.String())
+	}
+	c.network.mu.Unlock()
+	c.fail(net.ErrClosed)
+	return nil
+}
+
+// datagram is a received datagram, or the error a single read returns
+// instead.
+type datagram struct {
+	data []byte
+	addr *net.UDPAddr
+	err  error
+}
+
+// queue holds received datagrams for conns that don't read from a socket
+// directly, and implements their reading side.
+type queue struct {
+	incoming chan datagram
+	// failed is closed with err set once nothing more can be read.
+	failed    chan struct{}
+	err       error
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func newQueue() *queue {
+	return &queue{incoming: make(chan datagram, 256), failed: make(chan struct{})}
+}
+
+// push queues d, or drops it if the queue is full, as a full socket buffer
+// would.
+func (q *queue) push(d datagram) {
+	select {
+	case q.incoming <- d:
+	default:
+	}
+}
+
+// fail makes reads return err from now on.
+func (q *queue) fail(err error) {
+	q.closeOnce.Do(func() {
+		q.err = err
+		close(q.failed)
+	})
+}
+
+func (q *queue) isClosed() bool {
+	return isClosed(q.failed)
+}
+
+func (q *queue) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	q.mu.Lock()
+	deadline := q.deadline
+	q.mu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case d := <-q.incoming:
+		if d.err != nil {
+			return 0, nil, d.err
+		}
+		return copy(b, d.data), d.addr, nil
+	case <-q.failed:
+		return 0, nil, q.err
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (q *queue) SetReadDeadline(t time.Time) error {
+	q.mu.Lock()
+	q.deadline = t
+	q.mu.Unlock()
+	return nil
+}
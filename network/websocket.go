@@ -0,0 +1,460 @@
+package network
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// websocketGUID is appended to the client's key to compute the accept
+	// key of the opening handshake (RFC 6455).
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// maxWebSocketMessage bounds the messages read from a WebSocket; each
+	// carries one datagram.
+	maxWebSocketMessage = 1 << 16
+	// maxWebSocketPeers bounds the WebSocket connections a listener keeps
+	// open at once.
+	maxWebSocketPeers = 256
+	// websocketHeaderTimeout bounds how long a connection may take to send
+	// the headers of its opening handshake.
+	websocketHeaderTimeout = 5 * time.Second
+	// websocketIdleTimeout closes connections that send nothing for this
+	// long, both HTTP connections between requests and WebSockets, whose
+	// clients send a heartbeat every second.
+	websocketIdleTimeout = 30 * time.Second
+	// websocketWriteTimeout bounds how long writing one frame may take. A
+	// connection whose other end stops reading is closed after it.
+	websocketWriteTimeout = time.Second
+	// websocketSendQueue is the number of datagrams queued for sending to
+	// a connection; more are dropped, as UDP would.
+	websocketSendQueue = 64
+	// maxWebSocketControl bounds the payload of control frames (RFC 6455,
+	// section 5.5).
+	maxWebSocketControl = 125
+)
+
+// WebSocket opcodes.
+const (
+	wsContinuation byte = 0x0
+	wsText         byte = 0x1
+	wsBinary       byte = 0x2
+	wsClose        byte = 0x8
+	wsPing         byte = 0x9
+	wsPong         byte = 0xA
+)
+
+// DefaultWebSocketPath is the HTTP path WebSocketTransport uses when its
+// Path is empty.
+const DefaultWebSocketPath = "/pong"
+
+// WebSocketTransport carries datagrams as binary WebSocket messages, one
+// encoded Message each, so that browsers can join. Listening serves the
+// WebSocket endpoint over HTTP at address; each connection then appears
+// as a client whose address is its TCP address.
+type WebSocketTransport struct {
+	// Path is the HTTP path of the endpoint.
+	Path string
+	// AllowedOrigins lists the origins, such as "https://example.com", of
+	// the web pages allowed to connect, or "*" for any. Requests without an
+	// Origin header, which browsers always send, and pages served by the
+	// endpoint's own host are allowed as well.
+	AllowedOrigins []string
+}
+
+func (t WebSocketTransport) path() string {
+	if t.Path == "" {
+		return DefaultWebSocketPath
+	}
+	return t.Path
+}
+
+// Listen serves the WebSocket endpoint at address and returns a conn
+// receiving the messages of all its connections.
+func (t WebSocketTransport) Listen(address string) (Conn, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	l := &wsListener{queue: newQueue(), ln: ln, origins: t.AllowedOrigins, peers: make(map[string]*wsPeer)}
+	mux := http.NewServeMux()
+	mux.HandleFunc(t.path(), l.upgrade)
+	l.http = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: websocketHeaderTimeout,
+		IdleTimeout:       websocketIdleTimeout,
+	}
+	go l.http.Serve(ln)
+	return l, nil
+}
+
+// Dial connects to the WebSocket endpoint at address. local is ignored;
+// the system picks the local TCP port.
+func (t WebSocketTransport) Dial(local *net.UDPAddr, address string) (Conn, error) {
+	tcp, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req, err := http.NewRequest(http.MethodGet, "http://"+address+t.path(), nil)
+	if err != nil {
+		tcp.Close()
+		return nil, err
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if err := req.Write(tcp); err != nil {
+		tcp.Close()
+		return nil, err
+	}
+	r := bufio.NewReader(tcp)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		tcp.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		tcp.Close()
+		return nil, fmt.Errorf("websocket handshake with %s failed: %s", address, resp.Status)
+	}
+	c := &wsClientConn{queue: newQueue(), peer: &wsPeer{conn: tcp, r: r, masked: true}, addr: udpAddrOf(tcp.RemoteAddr())}
+	go c.receive()
+	return c, nil
+}
+
+// websocketAccept computes the Sec-WebSocket-Accept value for key.
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// udpAddrOf converts a TCP address into the UDP address that datagrams
+// from it are reported with.
+func udpAddrOf(addr net.Addr) *net.UDPAddr {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return &net.UDPAddr{}
+	}
+	return &net.UDPAddr{IP: tcp.IP, Port: tcp.Port, Zone: tcp.Zone}
+}
+
+// wsPeer is one end of a WebSocket connection.
+type wsPeer struct {
+	conn net.Conn
+	r    *bufio.Reader
+	// masked is set on the client side, which must mask its frames and
+	// only accepts unmasked ones.
+	masked bool
+	mu     sync.Mutex
+	// out queues the datagrams for a listener's connection, which its
+	// writer goroutine sends so that a slow reader never holds up the
+	// server; nil on the client side.
+	out chan []byte
+}
+
+// writeFrame sends a single, final frame. A frame that can't be written
+// within websocketWriteTimeout closes the connection, as the other end has
+// stopped reading or a partly written frame has broken the stream.
+func (p *wsPeer) writeFrame(op byte, payload []byte) error {
+	header := []byte{0x80 | op, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	if p.masked {
+		var key [4]byte
+		rand.Read(key[:])
+		header[1] |= 0x80
+		header = append(header, key[:]...)
+		masked := make([]byte, len(payload))
+		for i, b := range payload {
+			masked[i] = b ^ key[i%4]
+		}
+		payload = masked
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	if _, err := p.conn.Write(append(header, payload...)); err != nil {
+		p.conn.Close()
+		return err
+	}
+	return nil
+}
+
+// writeLoop sends the datagrams queued on out until it is closed or a write
+// fails.
+func (p *wsPeer) writeLoop() {
+	for data := range p.out {
+		if err := p.writeFrame(wsBinary, data); err != nil {
+			return
+		}
+	}
+}
+
+// readMessage returns the next data message, answering pings and closes
+// on the way. A closed connection returns io.EOF, and a frame masked the
+// wrong way for this end or a fragmented or oversized control frame is an
+// error, after which the connection must be closed.
+func (p *wsPeer) readMessage() ([]byte, error) {
+	var message []byte
+	for {
+		var head [2]byte
+		if _, err := io.ReadFull(p.r, head[:]); err != nil {
+			return nil, err
+		}
+		fin, op := head[0]&0x80 != 0, head[0]&0x0F
+		length := uint64(head[1] & 0x7F)
+		switch length {
+		case 126:
+			var n uint16
+			if err := binary.Read(p.r, binary.BigEndian, &n); err != nil {
+				return nil, err
+			}
+			length = uint64(n)
+		case 127:
+			if err := binary.Read(p.r, binary.BigEndian, &length); err != nil {
+				return nil, err
+			}
+		}
+		if length > maxWebSocketMessage || uint64(len(message))+length > maxWebSocketMessage {
+			return nil, errors.New("websocket message too large")
+		}
+		if op >= wsClose && (!fin || length > maxWebSocketControl) {
+			return nil, errors.New("websocket control frame fragmented or too large")
+		}
+		if masked := head[1]&0x80 != 0; masked == p.masked {
+			return nil, errors.New("websocket frame masked wrongly")
+		}
+		var key [4]byte
+		if head[1]&0x80 != 0 {
+			if _, err := io.ReadFull(p.r, key[:]); err != nil {
+				return nil, err
+			}
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(p.r, payload); err != nil {
+			return nil, err
+		}
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+		switch op {
+		case wsPing:
+			p.writeFrame(wsPong, payload)
+		case wsPong:
+		case wsClose:
+			p.writeFrame(wsClose, nil)
+			return nil, io.EOF
+		case wsText, wsBinary, wsContinuation:
+			message = append(message, payload...)
+			if fin {
+				return message, nil
+			}
+		default:
+			return nil, fmt.Errorf("unknown websocket opcode %#x", op)
+		}
+	}
+}
+
+// wsListener is the conn of a WebSocket endpoint. It reads the messages of
+// every connection and writes to each by its address.
+type wsListener struct {
+	*queue
+	ln      net.Listener
+	http    *http.Server
+	origins []string
+
+	mu    sync.Mutex
+	peers map[string]*wsPeer
+}
+
+// upgrade completes the opening handshake and reads the connection's
+// messages until it closes.
+func (l *wsListener) upgrade(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "expected a WebSocket upgrade", http.StatusBadRequest)
+		return
+	}
+	if !l.allowOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "upgrade not supported", http.StatusInternalServerError)
+		return
+	}
+	l.mu.Lock()
+	full := len(l.peers) >= maxWebSocketPeers
+	l.mu.Unlock()
+	if full {
+		http.Error(w, "too many connections", http.StatusServiceUnavailable)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		return
+	}
+	peer := &wsPeer{conn: conn, r: rw.Reader, out: make(chan []byte, websocketSendQueue)}
+	addr := udpAddrOf(conn.RemoteAddr())
+	l.mu.Lock()
+	l.peers[addr.String()] = peer
+	l.mu.Unlock()
+	go peer.writeLoop()
+	defer func() {
+		l.mu.Lock()
+		delete(l.peers, addr.String())
+		close(peer.out)
+		l.mu.Unlock()
+	}()
+	for !l.isClosed() {
+		conn.SetReadDeadline(time.Now().Add(websocketIdleTimeout))
+		data, err := peer.readMessage()
+		if err != nil {
+			return
+		}
+		l.push(datagram{data: data, addr: addr})
+	}
+}
+
+// allowOrigin reports whether the web page, if any, that r comes from may
+// connect.
+func (l *wsListener) allowOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range l.origins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// headerContains reports whether the comma-separated header name contains
+// token, ignoring case.
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WriteToUDP queues b for the connection at addr and never blocks. Like
+// UDP, sending to nobody or faster than the connection takes is not an
+// error; the datagram is dropped.
+func (l *wsListener) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if peer, ok := l.peers[addr.String()]; ok {
+		select {
+		case peer.out <- append([]byte(nil), b...):
+		default:
+		}
+	}
+	return len(b), nil
+}
+
+func (l *wsListener) Write(b []byte) (int, error) {
+	return 0, errors.New("websocket: write on listener")
+}
+
+func (l *wsListener) LocalAddr() net.Addr {
+	return l.ln.Addr()
+}
+
+func (l *wsListener) Close() error {
+	l.fail(net.ErrClosed)
+	err := l.http.Close()
+	l.mu.Lock()
+	for _, peer := range l.peers {
+		peer.writeFrame(wsClose, nil)
+		peer.conn.Close()
+	}
+	l.mu.Unlock()
+	return err
+}
+
+// wsClientConn is the conn of a dialed WebSocket.
+type wsClientConn struct {
+	*queue
+	peer *wsPeer
+	addr *net.UDPAddr
+}
+
+func (c *wsClientConn) receive() {
+	for {
+		data, err := c.peer.readMessage()
+		if err != nil {
+			c.peer.conn.Close()
+			if errors.Is(err, io.EOF) || c.isClosed() {
+				err = net.ErrClosed
+			}
+			c.fail(err)
+			return
+		}
+		c.push(datagram{data: data, addr: c.addr})
+	}
+}
+
+func (c *wsClientConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	return c.Write(b)
+}
+
+func (c *wsClientConn) Write(b []byte) (int, error) {
+	if err := c.peer.writeFrame(wsBinary, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *wsClientConn) LocalAddr() net.Addr {
+	return c.peer.conn.LocalAddr()
+}
+
+func (c *wsClientConn) Close() error {
+	c.peer.writeFrame(wsClose, nil)
+	c.fail(net.ErrClosed)
+	return c.peer.conn.Close()
+}
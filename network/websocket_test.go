@@ -0,0 +1,191 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// listenWebSocket serves a WebSocket endpoint on a free loopback port; it
+// is closed when the test ends.
+func listenWebSocket(t *testing.T, transport WebSocketTransport) *wsListener {
+	t.Helper()
+	conn, err := transport.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn.(*wsListener)
+}
+
+// rawUpgrade opens a TCP connection to l and sends an opening handshake
+// from origin, unless it is empty. It returns the connection, a reader
+// positioned after the response headers and the response's status code.
+func rawUpgrade(t *testing.T, l *wsListener, origin string) (net.Conn, *bufio.Reader, int) {
+	t.Helper()
+	conn, err := net.Dial("tcp", l.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	req, _ := http.NewRequest(http.MethodGet, "http://"+l.LocalAddr().String()+DefaultWebSocketPath, nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("writing the handshake: %v", err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatalf("reading the handshake response: %v", err)
+	}
+	resp.Body.Close()
+	return conn, r, resp.StatusCode
+}
+
+// writeRawFrame writes a masked frame with the given first header byte,
+// which holds the FIN bit and the opcode, as a client would.
+func writeRawFrame(t *testing.T, conn net.Conn, first byte, payload []byte) {
+	t.Helper()
+	header := []byte{first, 0x80}
+	if n := len(payload); n < 126 {
+		header[1] |= byte(n)
+	} else {
+		header[1] |= 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	}
+	// A zero masking key leaves the payload as it is.
+	header = append(header, 0, 0, 0, 0)
+	if _, err := conn.Write(append(header, payload...)); err != nil {
+		t.Fatalf("writing a frame: %v", err)
+	}
+}
+
+func TestWebSocketUpgrade(t *testing.T) {
+	l := listenWebSocket(t, WebSocketTransport{AllowedOrigins: []string{"https://pong.example.com"}})
+	tests := []struct {
+		origin string
+		want   int
+	}{
+		{"", http.StatusSwitchingProtocols},
+		{"https://pong.example.com", http.StatusSwitchingProtocols},
+		{"http://" + l.LocalAddr().String(), http.StatusSwitchingProtocols},
+		{"https://evil.example.com", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if _, _, status := rawUpgrade(t, l, tt.origin); status != tt.want {
+			t.Errorf("upgrade from origin %q: status %d, want %d", tt.origin, status, tt.want)
+		}
+	}
+}
+
+func TestWebSocketRoundTrip(t *testing.T) {
+	l := listenWebSocket(t, WebSocketTransport{})
+	client, err := WebSocketTransport{}.Dial(nil, l.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+
+	// Large enough to need the 16-bit length.
+	sent := bytes.Repeat([]byte("ping"), 100)
+	if _, err := client.Write(sent); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	buf := make([]byte, maxWebSocketMessage)
+	l.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, addr, err := l.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("listener ReadFromUDP: %v", err)
+	}
+	if !bytes.Equal(buf[:n], sent) {
+		t.Errorf("listener received %d bytes, want the %d sent", n, len(sent))
+	}
+
+	if _, err := l.WriteToUDP([]byte("pong"), addr); err != nil {
+		t.Fatalf("WriteToUDP: %v", err)
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err = client.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("client ReadFromUDP: %v", err)
+	}
+	if string(buf[:n]) != "pong" {
+		t.Errorf("client received %q, want %q", buf[:n], "pong")
+	}
+}
+
+func TestWebSocketControlFrames(t *testing.T) {
+	l := listenWebSocket(t, WebSocketTransport{})
+	tests := []struct {
+		name    string
+		first   byte
+		payload []byte
+		closed  bool
+	}{
+		{"ping", 0x80 | wsPing, []byte("hi"), false},
+		{"oversized ping", 0x80 | wsPing, make([]byte, maxWebSocketControl+1), true},
+		{"fragmented ping", wsPing, []byte("hi"), true},
+		{"fragmented close", wsClose, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, r, _ := rawUpgrade(t, l, "")
+			writeRawFrame(t, conn, tt.first, tt.payload)
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			var head [2]byte
+			_, err := io.ReadFull(r, head[:])
+			if tt.closed {
+				if err == nil {
+					t.Errorf("connection answered with opcode %#x, want it closed", head[0]&0x0F)
+				}
+				return
+			}
+			if err != nil || head[0]&0x0F != wsPong {
+				t.Errorf("answer %#x, %v; want a pong", head[0], err)
+			}
+		})
+	}
+}
+
+func TestWebSocketSlowReader(t *testing.T) {
+	l := listenWebSocket(t, WebSocketTransport{})
+	// A client that never reads what it is sent.
+	conn, _, status := rawUpgrade(t, l, "")
+	if status != http.StatusSwitchingProtocols {
+		t.Fatalf("upgrade status %d", status)
+	}
+	addr := udpAddrOf(conn.LocalAddr())
+
+	data := make([]byte, 60000)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		start := time.Now()
+		if _, err := l.WriteToUDP(data, addr); err != nil {
+			t.Fatalf("WriteToUDP: %v", err)
+		}
+		if d := time.Since(start); d > 100*time.Millisecond {
+			t.Fatalf("WriteToUDP blocked for %v", d)
+		}
+		l.mu.Lock()
+		_, ok := l.peers[addr.String()]
+		l.mu.Unlock()
+		if !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the connection that doesn't read was never dropped")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
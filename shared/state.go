@@ -13,6 +13,14 @@ type State struct {
 	ScoreLeft  int
 	ScoreRight int
 	Timestamp  int64
+	// InputSeq is the last input from the receiving client that the server
+	// had applied when this state was taken.
+	InputSeq uint32
+	// InputSeqs is the last input applied to each paddle, left then right.
+	// The server sends each player the one of its paddle as InputSeq.
+	InputSeqs [2]uint32
+	// Tick is the number of fixed simulation steps the server had run.
+	Tick uint64
 }
 
 // InterpolateState linearly interpolates between two States by t.
@@ -29,5 +37,8 @@ func InterpolateState(s1, s2 State, t float32) State {
 		ScoreLeft:  s2.ScoreLeft, // Use s2 directly (or choose differently)
 		ScoreRight: s2.ScoreRight,
 		Timestamp:  s1.Timestamp + int64(float32(s2.Timestamp-s1.Timestamp)*t),
+		InputSeq:   s2.InputSeq,
+		InputSeqs:  s2.InputSeqs,
+		Tick:       s2.Tick,
 	}
 }
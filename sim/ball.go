@@ -0,0 +1,31 @@
+package sim
+
+type Ball struct {
+	X, Y   float32
+	VX, VY float32
+	Size   int32 // represents the diameter of the ball
+}
+
+func NewBall(x, y float32) *Ball {
+	return &Ball{
+		X:    x,
+		Y:    y,
+		VX:   250, // pixels per second
+		VY:   250,
+		Size: 20, // Increased size for a smoother, rounder ball.
+	}
+}
+
+func (b *Ball) Update(deltaTime float32) {
+	b.X += b.VX * deltaTime
+	b.Y += b.VY * deltaTime
+
+	// Bounce off top and bottom walls (assuming window height 600)
+	if b.Y <= 0 {
+		b.Y = 0
+		b.VY = -b.VY
+	} else if b.Y+float32(b.Size) >= 600 {
+		b.Y = 600 - float32(b.Size)
+		b.VY = -b.VY
+	}
+}
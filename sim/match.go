@@ -0,0 +1,310 @@
+package sim
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"pong-multiplayer/shared"
+)
+
+const (
+	// FieldWidth and FieldHeight are the size of the playing field, which
+	// is also the size of the window.
+	FieldWidth  = 800
+	FieldHeight = 600
+	// paddleStartY is where both paddles start.
+	paddleStartY = 250
+)
+
+// State represents the minimal game state to share with clients.
+type State struct {
+	BallX, BallY          float32
+	BallVX, BallVY        float32
+	P1X, P1Y              float32
+	P2X, P2Y              float32
+	ScoreLeft, ScoreRight int
+	Timestamp             int64
+}
+
+// Match is the state of one match and the rules that advance it. It has
+// no notion of a window or a keyboard: the paddles are moved by the inputs
+// passed to ApplyInput and SetLocalDirection.
+type Match struct {
+	Ball       *Ball
+	Player1    *Player
+	Player2    *Player
+	ScoreLeft  int
+	ScoreRight int
+	LocalSlot  int // paddle controlled on this machine: 0 = left, 1 = right, -1 = none
+	// Tick counts the fixed simulation steps run so far.
+	Tick uint64
+
+	// mu guards the simulation against concurrent Step, GetState and
+	// ApplyInput calls from the network goroutines, and against Reset from
+	// the admin console while the window draws the match.
+	mu sync.Mutex
+	// inputs holds each paddle's remote inputs not yet fully applied.
+	inputs [2][]queuedInput
+	// inputSeqs is the sequence number of the last input Step applied to
+	// each paddle.
+	inputSeqs [2]uint32
+	// localDirection is the direction the local paddle moves in each step,
+	// as set by SetLocalDirection.
+	localDirection int
+	accumulator    time.Duration
+	paused         bool
+}
+
+// NewMatch creates a match with the ball in the center and both paddles
+// at their starting positions.
+func NewMatch() *Match {
+	// Host (Player1) is on the left; remote player (Player2) is on the right.
+	p1 := NewPlayer(30, paddleStartY)  // Left paddle
+	p2 := NewPlayer(760, paddleStartY) // Right paddle
+
+	return &Match{
+		Ball:       NewBall(float32(FieldWidth/2-5), float32(FieldHeight/2-5)),
+		Player1:    p1,
+		Player2:    p2,
+		ScoreLeft:  0,
+		ScoreRight: 0,
+	}
+}
+
+func (m *Match) Update(deltaTime float32) {
+	m.Ball.Update(deltaTime)
+	// Remote paddles are moved by ApplyInput as their inputs arrive, the
+	// local one by the direction the window last read from the keyboard.
+	if p := m.LocalPlayer(); p != nil && m.localDirection != 0 {
+		p.Move(m.localDirection, deltaTime)
+	}
+
+	m.checkPaddleCollision(m.Player1)
+	m.checkPaddleCollision(m.Player2)
+
+	// Example score logic – adjust as needed.
+	if m.Ball.X < 0 {
+		// Ball left the screen: right player scores.
+		m.ScoreRight++
+		m.resetBall()
+	} else if m.Ball.X > FieldWidth {
+		// Ball went off right side: left player scores.
+		m.ScoreLeft++
+		m.resetBall()
+	}
+}
+
+// maxInputDeltaTime caps the duration of a single input. Step applies no
+// more than one tick of input per paddle anyway, so this only bounds how
+// far a paddle keeps moving on one message once its player stops sending.
+const maxInputDeltaTime = 0.1
+
+// maxQueuedInputs caps the inputs of one paddle waiting to be applied,
+// which are dropped beyond it, e.g. while no match is running yet.
+const maxQueuedInputs = 64
+
+// clampInput limits an input to a direction of -1, 0 or +1 and a duration
+// of at most maxInputDeltaTime. It reports false for a duration that is
+// not a positive, finite number of seconds.
+func clampInput(direction int, deltaTime float32) (int, float32, bool) {
+	if !(deltaTime > 0) || math.IsInf(float64(deltaTime), 1) {
+		return 0, 0, false
+	}
+	return max(-1, min(direction, 1)), min(deltaTime, maxInputDeltaTime), true
+}
+
+// ApplyInput queues one remote input command for the paddle in slot
+// (0 = left, 1 = right), with the sequence number the client sent it with.
+// Step applies it after the ones queued before it, at most one tick of
+// input per paddle and step, so a client can't move its paddle faster than
+// its Speed by sending more or longer inputs. Inputs with a bad duration
+// or for another slot are dropped.
+func (m *Match) ApplyInput(slot int, direction int, deltaTime float32, seq uint32) {
+	direction, deltaTime, ok := clampInput(direction, deltaTime)
+	if !ok || slot < 0 || slot >= len(m.inputs) {
+		return
+	}
+	m.mu.Lock()
+	if len(m.inputs[slot]) < maxQueuedInputs {
+		m.inputs[slot] = append(m.inputs[slot], queuedInput{direction: direction, deltaTime: deltaTime, seq: seq})
+	}
+	m.mu.Unlock()
+}
+
+func (m *Match) checkPaddleCollision(p *Player) {
+	// Simple AABB collision detection.
+	if m.Ball.X <= p.X+float32(p.Width) &&
+		m.Ball.X+float32(m.Ball.Size) >= p.X &&
+		m.Ball.Y+float32(m.Ball.Size) >= p.Y &&
+		m.Ball.Y <= p.Y+float32(p.Height) {
+		// Reverse the ball's horizontal direction.
+		m.Ball.VX = -m.Ball.VX
+	}
+}
+
+func (m *Match) resetBall() {
+	// Place the ball back in the center.
+	m.Ball.X = float32(FieldWidth)/2 - float32(m.Ball.Size)/2
+	m.Ball.Y = float32(FieldHeight)/2 - float32(m.Ball.Size)/2
+
+	// Reset velocity (for simplicity, use fixed speeds).
+	if m.Ball.VX < 0 {
+		m.Ball.VX = 250
+	} else {
+		m.Ball.VX = -250
+	}
+	m.Ball.VY = 250
+}
+
+// RunHeadless runs the simulation without rendering or local input,
+// for use by a dedicated server, until done is closed.
+func (m *Match) RunHeadless(done <-chan struct{}) {
+	lastTime := time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		now := time.Now()
+		m.Advance(now.Sub(lastTime))
+		lastTime = now
+
+		time.Sleep(TickDuration)
+	}
+}
+
+// LocalPlayer returns the paddle controlled on this machine, or nil when
+// spectating.
+func (m *Match) LocalPlayer() *Player {
+	switch m.LocalSlot {
+	case 0:
+		return m.Player1
+	case 1:
+		return m.Player2
+	}
+	return nil
+}
+
+// Score returns the score of the left and right player.
+func (m *Match) Score() (left, right int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ScoreLeft, m.ScoreRight
+}
+
+// GetState returns the current game state.
+func (m *Match) GetState() shared.State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return shared.State{
+		BallX:      m.Ball.X,
+		BallY:      m.Ball.Y,
+		BallVX:     m.Ball.VX,
+		BallVY:     m.Ball.VY,
+		P1X:        m.Player1.X,
+		P1Y:        m.Player1.Y,
+		P2X:        m.Player2.X,
+		P2Y:        m.Player2.Y,
+		ScoreLeft:  m.ScoreLeft,
+		ScoreRight: m.ScoreRight,
+		Timestamp:  time.Now().UnixNano(),
+		Tick:       m.Tick,
+		InputSeqs:  m.inputSeqs,
+	}
+}
+
+// Positions returns copies of the ball and both paddles, taken together
+// so that they can be drawn while other goroutines change the match.
+func (m *Match) Positions() (ball Ball, p1, p2 Player) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return *m.Ball, *m.Player1, *m.Player2
+}
+
+// SetState applies a given state to the game instance.
+func (m *Match) SetState(s State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Ball.X = s.BallX
+	m.Ball.Y = s.BallY
+	m.Ball.VX = s.BallVX
+	m.Ball.VY = s.BallVY
+	m.Player1.X = s.P1X
+	m.Player1.Y = s.P1Y
+	m.Player2.X = s.P2X
+	m.Player2.Y = s.P2Y
+	m.ScoreLeft = s.ScoreLeft
+	m.ScoreRight = s.ScoreRight
+}
+
+// SetStateSmooth applies a received state smoothly to the game instance.
+func (m *Match) SetStateSmooth(s shared.State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Update ball and local player (Player1) immediately.
+	m.Ball.X = s.BallX
+	m.Ball.Y = s.BallY
+	m.Ball.VX = s.BallVX
+	m.Ball.VY = s.BallVY
+	m.Player1.X = s.P1X
+	m.Player1.Y = s.P1Y
+
+	// Smoothly update Player2 (client's paddle)
+	const smoothing = 0.2
+	m.Player2.X += smoothing * (s.P2X - m.Player2.X)
+	m.Player2.Y += smoothing * (s.P2Y - m.Player2.Y)
+
+	// Directly update the score so that it stays in sync.
+	m.ScoreLeft = s.ScoreLeft
+	m.ScoreRight = s.ScoreRight
+}
+
+// ApplyRemoteState updates only the remote objects (and score)
+// without altering the local player's paddle.
+func (m *Match) ApplyRemoteState(s shared.State, isClient bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Always update the ball and score.
+	m.Ball.X = s.BallX
+	m.Ball.Y = s.BallY
+	m.Ball.VX = s.BallVX
+	m.Ball.VY = s.BallVY
+	m.ScoreLeft = s.ScoreLeft
+	m.ScoreRight = s.ScoreRight
+
+	if isClient {
+		// On the join client, update every paddle but the one controlled
+		// locally, which is updated by local input.
+		if m.LocalSlot != 1 {
+			m.Player2.X = s.P2X
+			m.Player2.Y = s.P2Y
+		}
+		if m.LocalSlot != 0 {
+			m.Player1.X = s.P1X
+			m.Player1.Y = s.P1Y
+		}
+	} else {
+		// On the host, update the remote paddle (Player2) smoothly.
+		const smoothing = 0.2
+		m.Player2.X += smoothing * (s.P2X - m.Player2.X)
+		m.Player2.Y += smoothing * (s.P2Y - m.Player2.Y)
+	}
+}
+
+func InterpolateState(s1, s2 State, t float32) State {
+	return State{
+		BallX:      s1.BallX + t*(s2.BallX-s1.BallX),
+		BallY:      s1.BallY + t*(s2.BallY-s1.BallY),
+		BallVX:     s1.BallVX + t*(s2.BallVX-s1.BallVX),
+		BallVY:     s1.BallVY + t*(s2.BallVY-s1.BallVY),
+		P1X:        s1.P1X + t*(s2.P1X-s1.P1X),
+		P1Y:        s1.P1Y + t*(s2.P1Y-s1.P1Y),
+		P2X:        s1.P2X + t*(s2.P2X-s1.P2X),
+		P2Y:        s1.P2Y + t*(s2.P2Y-s1.P2Y),
+		ScoreLeft:  s2.ScoreLeft, // Use the latest score
+		ScoreRight: s2.ScoreRight,
+		Timestamp:  s2.Timestamp,
+	}
+}
@@ -0,0 +1,256 @@
+package sim
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// run steps m n times.
+func run(m *Match, n int) {
+	for range n {
+		m.Step()
+	}
+}
+
+func TestBallBounces(t *testing.T) {
+	tests := []struct {
+		name           string
+		ball           Ball
+		wantVX, wantVY float32
+	}{
+		{"top wall", Ball{X: 400, Y: 1, VX: 250, VY: -250, Size: 20}, 250, 250},
+		{"bottom wall", Ball{X: 400, Y: FieldHeight - 21, VX: 250, VY: 250, Size: 20}, 250, -250},
+		{"left paddle", Ball{X: 41, Y: 290, VX: -250, VY: 0, Size: 20}, 250, 0},
+		{"right paddle", Ball{X: 739, Y: 290, VX: 250, VY: 0, Size: 20}, -250, 0},
+		{"open field", Ball{X: 400, Y: 300, VX: 250, VY: 250, Size: 20}, 250, 250},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMatch()
+			*m.Ball = tt.ball
+			m.Step()
+			if m.Ball.VX != tt.wantVX || m.Ball.VY != tt.wantVY {
+				t.Errorf("velocity = (%v, %v), want (%v, %v)", m.Ball.VX, m.Ball.VY, tt.wantVX, tt.wantVY)
+			}
+			if m.Ball.Y < 0 || m.Ball.Y+float32(m.Ball.Size) > FieldHeight {
+				t.Errorf("ball left the field at Y = %v", m.Ball.Y)
+			}
+		})
+	}
+}
+
+func TestScoring(t *testing.T) {
+	tests := []struct {
+		name      string
+		ball      Ball
+		wantLeft  int
+		wantRight int
+		wantVX    float32
+	}{
+		// The ball is served towards the player who just conceded.
+		{"past the left paddle", Ball{X: 1, Y: 20, VX: -250, VY: 250, Size: 20}, 0, 1, 250},
+		{"past the right paddle", Ball{X: FieldWidth - 1, Y: 20, VX: 250, VY: 250, Size: 20}, 1, 0, -250},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMatch()
+			*m.Ball = tt.ball
+			m.Step()
+			if left, right := m.Score(); left != tt.wantLeft || right != tt.wantRight {
+				t.Errorf("score = %d-%d, want %d-%d", left, right, tt.wantLeft, tt.wantRight)
+			}
+			wantX := float32(FieldWidth)/2 - float32(m.Ball.Size)/2
+			if m.Ball.X != wantX || m.Ball.VX != tt.wantVX {
+				t.Errorf("ball at X = %v moving %v, want served from %v moving %v", m.Ball.X, m.Ball.VX, wantX, tt.wantVX)
+			}
+		})
+	}
+}
+
+func TestApplyInput(t *testing.T) {
+	m := NewMatch()
+	m.ApplyInput(0, 1, tickDeltaTime, 5)
+	m.ApplyInput(1, -1, tickDeltaTime/2, 9)
+	// Inputs for a slot without a paddle are dropped.
+	m.ApplyInput(2, 1, 0.1, 11)
+	m.ApplyInput(-1, 1, 0.1, 12)
+
+	if m.Player1.Y != paddleStartY {
+		t.Fatal("ApplyInput moved the paddle before the next step")
+	}
+	m.Step()
+	if want := paddleStartY + m.Player1.Speed*tickDeltaTime; m.Player1.Y != want {
+		t.Errorf("left paddle at %v, want %v", m.Player1.Y, want)
+	}
+	if want := paddleStartY - m.Player2.Speed*tickDeltaTime/2; m.Player2.Y != want {
+		t.Errorf("right paddle at %v, want %v", m.Player2.Y, want)
+	}
+	if seqs := m.GetState().InputSeqs; seqs != [2]uint32{5, 9} {
+		t.Errorf("InputSeqs = %v, want [5 9]", seqs)
+	}
+
+	// Paddles stay inside the field.
+	for range 300 {
+		m.ApplyInput(0, -1, 0.1, 6)
+		m.Step()
+	}
+	if m.Player1.Y != 0 {
+		t.Errorf("left paddle at %v after moving up for long, want 0", m.Player1.Y)
+	}
+}
+
+func TestApplyInputRejects(t *testing.T) {
+	tests := []struct {
+		name      string
+		direction int
+		deltaTime float32
+		wantMove  float32 // in ticks of movement at the paddle's speed
+	}{
+		{"direction clamped down", 127, tickDeltaTime, 1},
+		{"direction clamped up", -128, tickDeltaTime, -1},
+		{"zero duration", 1, 0, 0},
+		{"negative duration", 1, -0.05, 0},
+		{"NaN duration", 1, float32(math.NaN()), 0},
+		{"infinite duration", 1, float32(math.Inf(1)), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMatch()
+			m.ApplyInput(0, tt.direction, tt.deltaTime, 1)
+			m.Step()
+			want := paddleStartY + tt.wantMove*m.Player1.Speed*tickDeltaTime
+			if m.Player1.Y != want {
+				t.Errorf("paddle at %v, want %v", m.Player1.Y, want)
+			}
+		})
+	}
+}
+
+func TestApplyInputTickCap(t *testing.T) {
+	m := NewMatch()
+	// A flood of inputs moves the paddle no faster than its speed.
+	for seq := range uint32(maxQueuedInputs * 2) {
+		m.ApplyInput(1, 1, 0.1, seq+1)
+	}
+	m.Step()
+	if want := paddleStartY + m.Player2.Speed*tickDeltaTime; m.Player2.Y != want {
+		t.Errorf("right paddle at %v after one step, want %v", m.Player2.Y, want)
+	}
+	if seqs := m.GetState().InputSeqs; seqs[1] != 0 {
+		t.Errorf("InputSeqs[1] = %d while the first input is half applied, want 0", seqs[1])
+	}
+
+	// The rest of a long input is carried over to the following steps and
+	// its sequence number reported once it has been applied in full.
+	m = NewMatch()
+	m.ApplyInput(0, 1, 2.5*tickDeltaTime, 7)
+	run(m, 2)
+	if seqs := m.GetState().InputSeqs; seqs[0] != 0 {
+		t.Errorf("InputSeqs[0] = %d after two steps, want 0", seqs[0])
+	}
+	m.Step()
+	if seqs := m.GetState().InputSeqs; seqs[0] != 7 {
+		t.Errorf("InputSeqs[0] = %d after three steps, want 7", seqs[0])
+	}
+	want := paddleStartY + m.Player1.Speed*2.5*tickDeltaTime
+	if diff := m.Player1.Y - want; diff < -1e-3 || diff > 1e-3 {
+		t.Errorf("left paddle at %v, want %v", m.Player1.Y, want)
+	}
+
+	// Reset drops the inputs not applied yet.
+	m.ApplyInput(0, 1, 0.1, 8)
+	m.Reset()
+	m.Step()
+	if m.Player1.Y != paddleStartY {
+		t.Errorf("left paddle at %v after Reset, want %v", m.Player1.Y, float32(paddleStartY))
+	}
+}
+
+func TestPausedStep(t *testing.T) {
+	m := NewMatch()
+	m.SetPaused(true)
+	ball := *m.Ball
+	m.ApplyInput(0, 1, 0.1, 1)
+	m.Step()
+	if *m.Ball != ball || m.Tick != 0 {
+		t.Errorf("paused step moved the ball to %+v at tick %d", *m.Ball, m.Tick)
+	}
+	if m.Player1.Y == paddleStartY {
+		t.Error("paused step didn't apply the queued input")
+	}
+	m.SetPaused(false)
+	m.Step()
+	if m.Tick != 1 {
+		t.Errorf("Tick = %d after resuming, want 1", m.Tick)
+	}
+}
+
+func TestLocalDirection(t *testing.T) {
+	m := NewMatch()
+	m.LocalSlot = 1
+	m.SetLocalDirection(-1)
+	run(m, TickRate/2)
+	want := paddleStartY - m.Player2.Speed/2
+	if diff := m.Player2.Y - want; diff > 0.01 || diff < -0.01 {
+		t.Errorf("local paddle at %v after half a second, want %v", m.Player2.Y, want)
+	}
+	if m.Player1.Y != paddleStartY {
+		t.Errorf("remote paddle moved to %v", m.Player1.Y)
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	m := NewMatch()
+	m.Advance(3*TickDuration + TickDuration/2)
+	if m.Tick != 3 {
+		t.Errorf("Tick = %d, want 3", m.Tick)
+	}
+	// The remainder carries over to the next call.
+	m.Advance(TickDuration - TickDuration/2)
+	if m.Tick != 4 {
+		t.Errorf("Tick = %d after the remainder, want 4", m.Tick)
+	}
+	// A stall is only caught up on up to maxFrameTime.
+	m.Advance(10 * time.Second)
+	if want := 4 + uint64(maxFrameTime/TickDuration); m.Tick != want {
+		t.Errorf("Tick = %d after a stall, want %d", m.Tick, want)
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	a, b := NewMatch(), NewMatch()
+	for tick := range 5 * TickRate {
+		for _, m := range []*Match{a, b} {
+			if tick%7 == 0 {
+				m.ApplyInput(tick%2, 1-tick%3, 0.02, uint32(tick))
+			}
+			m.Step()
+		}
+	}
+	sa, sb := a.GetState(), b.GetState()
+	sa.Timestamp, sb.Timestamp = 0, 0
+	if sa != sb {
+		t.Errorf("matches fed the same inputs diverged:\n%+v\n%+v", sa, sb)
+	}
+	if sa.Tick != 5*TickRate {
+		t.Errorf("Tick = %d, want %d", sa.Tick, 5*TickRate)
+	}
+}
+
+func TestPredictorReconcile(t *testing.T) {
+	var pr Predictor
+	p := NewPlayer(30, paddleStartY)
+	for range 3 {
+		pr.Apply(p, 1, 0.1)
+	}
+	// The server has applied the first input only, and its paddle sits a
+	// little higher than predicted.
+	pr.Reconcile(p, paddleStartY+20, 1)
+	if pr.Pending() != 2 {
+		t.Errorf("Pending = %d, want 2", pr.Pending())
+	}
+	if want := float32(paddleStartY + 20 + 60); p.Y != want {
+		t.Errorf("reconciled paddle at %v, want %v", p.Y, want)
+	}
+}
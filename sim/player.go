@@ -0,0 +1,31 @@
+package sim
+
+// Player represents a paddle in the game.
+type Player struct {
+	X, Y          float32
+	Width, Height int32
+	Speed         float32
+}
+
+// NewPlayer creates a new player (paddle) at the specified position.
+func NewPlayer(x, y float32) *Player {
+	return &Player{
+		X:      x,
+		Y:      y,
+		Width:  10,
+		Height: 100,
+		Speed:  300, // pixels per second
+	}
+}
+
+// Move moves the paddle in direction (-1 up, +1 down) for deltaTime seconds.
+// It is the single movement rule shared by the server and client prediction.
+func (p *Player) Move(direction int, deltaTime float32) {
+	p.Y += float32(direction) * p.Speed * deltaTime
+	// Clamp within window bounds (assuming window height 600)
+	if p.Y < 0 {
+		p.Y = 0
+	} else if p.Y+float32(p.Height) > 600 {
+		p.Y = 600 - float32(p.Height)
+	}
+}
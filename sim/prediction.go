@@ -0,0 +1,54 @@
+package sim
+
+// PendingInput is an input that has been applied locally but not yet
+// confirmed by the server.
+type PendingInput struct {
+	Seq       uint32
+	Direction int
+	DeltaTime float32
+}
+
+// Predictor implements client-side prediction for the locally controlled
+// paddle. Every input is applied immediately and remembered until a server
+// snapshot reports it as processed; on each snapshot the paddle is reset to
+// the authoritative position and the remaining inputs are replayed on top.
+type Predictor struct {
+	nextSeq uint32
+	pending []PendingInput
+}
+
+// Apply moves p for one local input and returns the sequence number to
+// send with it to the server.
+func (pr *Predictor) Apply(p *Player, direction int, deltaTime float32) uint32 {
+	// Predict what the server will apply: the clamped input, or nothing.
+	direction, deltaTime, ok := clampInput(direction, deltaTime)
+	if !ok {
+		direction, deltaTime = 0, 0
+	}
+	pr.nextSeq++
+	in := PendingInput{Seq: pr.nextSeq, Direction: direction, DeltaTime: deltaTime}
+	pr.pending = append(pr.pending, in)
+	p.Move(in.Direction, in.DeltaTime)
+	return in.Seq
+}
+
+// Reconcile sets p to the authoritative position authY, which the server
+// computed after processing every input up to lastProcessed, and replays
+// the inputs the server has not seen yet.
+func (pr *Predictor) Reconcile(p *Player, authY float32, lastProcessed uint32) {
+	i := 0
+	for i < len(pr.pending) && pr.pending[i].Seq <= lastProcessed {
+		i++
+	}
+	pr.pending = pr.pending[i:]
+
+	p.Y = authY
+	for _, in := range pr.pending {
+		p.Move(in.Direction, in.DeltaTime)
+	}
+}
+
+// Pending returns the number of inputs not yet confirmed by the server.
+func (pr *Predictor) Pending() int {
+	return len(pr.pending)
+}
@@ -0,0 +1,105 @@
+package sim
+
+import "time"
+
+const (
+	// TickRate is the number of fixed simulation steps per second.
+	TickRate = 120
+	// TickDuration is the wall-clock length of one simulation step.
+	TickDuration = time.Second / TickRate
+	// tickDeltaTime is the simulated time advanced by one step, in seconds.
+	tickDeltaTime float32 = 1.0 / TickRate
+	// maxFrameTime limits how much wall-clock time a single Advance call
+	// will catch up on, so a stall doesn't trigger a burst of steps.
+	maxFrameTime = 250 * time.Millisecond
+)
+
+// queuedInput is a remote input waiting to be applied; deltaTime is the
+// part of it Step has not applied yet.
+type queuedInput struct {
+	direction int
+	deltaTime float32
+	seq       uint32
+}
+
+// Step advances the simulation by exactly one fixed tick. Remote inputs
+// queued by ApplyInput are applied first, up to one tick of input per
+// paddle, and the rest waits for the following steps. So two matches fed
+// the same inputs between the same ticks end up in the same state, and
+// GetState reports the sequence number of the last input fully applied to
+// each paddle.
+func (m *Match) Step() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for slot, p := range [2]*Player{m.Player1, m.Player2} {
+		queue := m.inputs[slot]
+		budget := tickDeltaTime
+		for len(queue) > 0 && budget > 0 {
+			in := &queue[0]
+			dt := min(in.deltaTime, budget)
+			p.Move(in.direction, dt)
+			budget -= dt
+			if in.deltaTime -= dt; in.deltaTime > 0 {
+				break
+			}
+			m.inputSeqs[slot] = in.seq
+			queue = queue[1:]
+		}
+		m.inputs[slot] = append(m.inputs[slot][:0], queue...)
+	}
+
+	if m.paused {
+		return
+	}
+	m.Update(tickDeltaTime)
+	m.Tick++
+}
+
+// SetLocalDirection sets the direction (-1 up, +1 down, 0 none) the local
+// paddle moves in during the following steps, as read from local input.
+func (m *Match) SetLocalDirection(direction int) {
+	m.mu.Lock()
+	m.localDirection = direction
+	m.mu.Unlock()
+}
+
+// SetPaused stops or resumes the simulation. While paused, Step still
+// consumes queued inputs so paddles can be positioned, but the ball, the
+// score and the tick counter stand still.
+func (m *Match) SetPaused(paused bool) {
+	m.mu.Lock()
+	m.paused = paused
+	m.mu.Unlock()
+}
+
+// Paused reports whether the simulation is paused.
+func (m *Match) Paused() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.paused
+}
+
+// Reset starts the match over: the score is zeroed and the ball and
+// paddles go back to where they started.
+func (m *Match) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ScoreLeft, m.ScoreRight = 0, 0
+	m.Player1.Y, m.Player2.Y = paddleStartY, paddleStartY
+	m.inputs = [2][]queuedInput{}
+	m.resetBall()
+}
+
+// Advance accumulates elapsed wall-clock time and runs as many fixed steps
+// as fit into it, carrying the remainder over to the next call.
+func (m *Match) Advance(elapsed time.Duration) {
+	if elapsed > maxFrameTime {
+		elapsed = maxFrameTime
+	}
+	m.accumulator += elapsed
+	for m.accumulator >= TickDuration {
+		m.Step()
+		m.accumulator -= TickDuration
+	}
+}
@@ -0,0 +1,566 @@
+//go:build !headless
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"pong-multiplayer/engine"
+	"pong-multiplayer/game"
+	"pong-multiplayer/network"
+	"pong-multiplayer/shared"
+	"pong-multiplayer/sim"
+
+	"github.com/veandco/go-sdl2/sdl"
+	"github.com/veandco/go-sdl2/ttf"
+)
+
+const (
+	buttonWidth  = 200
+	buttonHeight = 50
+)
+
+// MenuState defines which part of the menu is active.
+type MenuState int
+
+const (
+	MenuMain MenuState = iota
+	MenuJoinInput
+)
+
+const defaultRenderDelay = int64(100 * 1e6) // 100ms in ns
+
+// reconnectAfter is how long the join client waits without hearing from the
+// server, which pings every second, before it reconnects with its session
+// token.
+const reconnectAfter = 3 * time.Second
+
+// runWindow opens the window and runs mode: "host", "join" or, if empty,
+// the menu that picks one of them.
+func runWindow(ctx context.Context, mode, address, invite string, open bool, rendezvous, websocket, admin string, encrypt bool) {
+	eng, err := engine.NewEngine("Multiplayer Pong", 800, 600)
+	if err != nil {
+		log.Fatalf("Engine initialization failed: %v", err)
+	}
+	defer eng.Shutdown()
+
+	// Initialize TTF
+	if err := ttf.Init(); err != nil {
+		log.Fatalf("TTF initialization failed: %v", err)
+	}
+	defer ttf.Quit()
+
+	// Open a font (ensure the TTF file exists in your working directory)
+	font, err := ttf.OpenFont("arial.ttf", 16)
+	if err != nil {
+		log.Fatalf("Failed to open font: %v", err)
+	}
+	defer font.Close()
+
+	// When hosting, use the given invite code or generate one.
+	inviteCode := hostInviteCode(invite, open)
+	joinInviteCode := invite // entered by the joining player
+	joinAddress := address   // picked from the LAN games in the menu
+
+	if mode == "" {
+		mode, joinInviteCode, joinAddress = runMenu(eng, font, inviteCode, joinAddress)
+	}
+
+	if mode == "host" {
+		runHost(ctx, eng, font, address, inviteCode, rendezvous, websocket, admin, encrypt)
+	} else if mode == "join" {
+		runJoin(ctx, eng, font, joinAddress, joinInviteCode, rendezvous)
+	}
+}
+
+// maxListedGames is how many LAN games the join menu lists.
+const maxListedGames = 6
+
+// gameRect returns the clickable row of the i-th LAN game in the join menu.
+func gameRect(i int) sdl.Rect {
+	return sdl.Rect{X: 150, Y: 80 + int32(i)*40, W: 500, H: 30}
+}
+
+// runMenu shows the main menu until the player picks a mode. It returns
+// "host" or "join" together with the typed invite code and the address to
+// join, which is the picked LAN game or else address, or an empty mode if
+// the window was closed.
+func runMenu(eng *engine.Engine, font *ttf.Font, inviteCode, address string) (string, string, string) {
+	var state MenuState = MenuMain
+	var selectedMode string   // "host" or "join"
+	var joinInviteCode string // entered by the joining player
+
+	// Define button rectangles.
+	hostBtn := sdl.Rect{X: 300, Y: 200, W: buttonWidth, H: buttonHeight}
+	joinBtn := sdl.Rect{X: 300, Y: 300, W: buttonWidth, H: buttonHeight}
+
+	// Games announced on the LAN are listed while entering the invite code.
+	var browser *network.Browser
+	defer func() {
+		if browser != nil {
+			browser.Close()
+		}
+	}()
+
+	// Main menu loop.
+	for {
+		var games []network.DiscoveredGame
+		if browser != nil {
+			games = browser.Games()
+			if len(games) > maxListedGames {
+				games = games[:maxListedGames]
+			}
+		}
+
+		for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+			switch ev := event.(type) {
+			case *sdl.QuitEvent:
+				return "", "", ""
+			case *sdl.MouseButtonEvent:
+				if ev.Type == sdl.MOUSEBUTTONDOWN {
+					x, y := ev.X, ev.Y
+					if state == MenuMain {
+						if pointInRect(int32(x), int32(y), hostBtn) {
+							selectedMode = "host"
+						} else if pointInRect(int32(x), int32(y), joinBtn) {
+							selectedMode = "join"
+							state = MenuJoinInput
+							joinInviteCode = ""
+							sdl.StartTextInput()
+							if browser == nil {
+								browser = network.NewBrowser()
+								if err := browser.Start(); err != nil {
+									log.Printf("LAN discovery unavailable: %v", err)
+									browser = nil
+								}
+							}
+						}
+					} else if state == MenuJoinInput {
+						for i, g := range games {
+							if !pointInRect(int32(x), int32(y), gameRect(i)) {
+								continue
+							}
+							address = g.Address
+							if !g.InviteRequired {
+								// Open games are joined right away.
+								state = MenuMain
+								sdl.StopTextInput()
+							}
+						}
+					}
+				}
+			case *sdl.TextInputEvent:
+				if state == MenuJoinInput {
+					// Convert the fixed C array to a Go slice and trim at the first null byte.
+					textArr := (*[32]byte)(unsafe.Pointer(&ev.Text))
+					n := 0
+					for ; n < len(textArr) && textArr[n] != 0; n++ {
+					}
+					joinInviteCode += string(textArr[:n])
+				}
+			case *sdl.KeyboardEvent:
+				if state == MenuJoinInput && ev.Type == sdl.KEYDOWN {
+					if ev.Keysym.Sym == sdl.K_BACKSPACE && len(joinInviteCode) > 0 {
+						joinInviteCode = joinInviteCode[:len(joinInviteCode)-1]
+					} else if ev.Keysym.Sym == sdl.K_RETURN {
+						// Finished entering invite code.
+						state = MenuMain
+						sdl.StopTextInput()
+						selectedMode = "join"
+					}
+				}
+			}
+		}
+
+		// Render the menu.
+		eng.Renderer.SetDrawColor(50, 50, 50, 255)
+		eng.Renderer.Clear()
+
+		if state == MenuMain {
+			drawButton(eng.Renderer, hostBtn, "Host ("+inviteCode+")")
+			drawButton(eng.Renderer, joinBtn, "Join")
+		} else if state == MenuJoinInput {
+			// List the LAN games, highlighting the picked one.
+			renderText(eng.Renderer, font, "Pick a LAN game or type an invite code, then press Enter", 150, 40)
+			for i, g := range games {
+				r := gameRect(i)
+				if g.Address == address {
+					eng.Renderer.SetDrawColor(100, 100, 255, 255)
+				} else {
+					eng.Renderer.SetDrawColor(80, 80, 80, 255)
+				}
+				eng.Renderer.FillRect(&r)
+				label := fmt.Sprintf("%s (%s)  %d open", g.Name, g.Address, g.OpenSlots)
+				if g.InviteRequired {
+					label += ", invite code needed"
+				}
+				renderText(eng.Renderer, font, label, r.X+5, r.Y+5)
+			}
+
+			// Draw input area.
+			inputRect := sdl.Rect{X: 300, Y: 400, W: buttonWidth, H: buttonHeight}
+			eng.Renderer.SetDrawColor(200, 200, 200, 255)
+			eng.Renderer.FillRect(&inputRect)
+			eng.Renderer.SetDrawColor(0, 0, 0, 255)
+			eng.Renderer.DrawRect(&inputRect)
+
+			// Render the typed text.
+			if joinInviteCode != "" {
+				surface, err := font.RenderUTF8Blended(joinInviteCode, sdl.Color{R: 0, G: 0, B: 0, A: 255})
+				if err == nil {
+					texture, err := eng.Renderer.CreateTextureFromSurface(surface)
+					if err == nil {
+						var tw, th int32
+						_, _, tw, th, err = texture.Query()
+						if err == nil {
+							dst := sdl.Rect{X: inputRect.X + 5, Y: inputRect.Y + (inputRect.H-th)/2, W: tw, H: th}
+							eng.Renderer.Copy(texture, nil, &dst)
+						}
+						texture.Destroy()
+					}
+					surface.Free()
+				}
+			}
+		}
+
+		eng.Renderer.Present()
+		sdl.Delay(16)
+		// Exit menu loop if a mode is selected.
+		if selectedMode != "" && state == MenuMain {
+			return selectedMode, joinInviteCode, address
+		}
+	}
+}
+
+// runHost starts a server in the background, connects to it as the left
+// player and runs the game locally once an opponent has joined.
+func runHost(ctx context.Context, eng *engine.Engine, font *ttf.Font, address, inviteCode, rendezvous, websocket, admin string, encrypt bool) {
+	// Create the server with the expected invite code.
+	server := network.NewServer(address, inviteCode)
+	server.Conditions = conditions
+	server.Encrypt = encrypt
+	server.Rendezvous = rendezvous
+	server.AnnounceName = announceName()
+
+	// Create the game instance. Callbacks must be in place before the
+	// server starts: input_update messages move the paddle belonging to
+	// the sender.
+	g := game.NewGame(eng)
+	server.InputUpdate = inputUpdateHandler(server, g.Match)
+	pauseOnDisconnect(server, g.Match)
+
+	// Immediately connect as client using the generated invite code.
+	// The host's own player reaches the server in memory rather than over
+	// a socket, and does so before the server listens for anyone else, so
+	// that no early remote player takes the left paddle. Its client picks
+	// up the spectator count for the HUD and carries the host's chat.
+	local := network.NewMemoryNetwork()
+	conn, err := local.Listen(address)
+	if err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
+	go server.Serve(conn)
+	client := network.NewClient(address)
+	client.Transport = local
+	handleMatchEvents(client, g)
+	connectChat(client, g)
+	if err := client.Connect(ctx, inviteCode); err != nil {
+		log.Fatalf("Client connection failed: %v", err)
+	}
+
+	go func() {
+		if err := server.Start(ctx); err != nil {
+			log.Fatalf("Server error: %v", err)
+		}
+	}()
+	log.Printf("Hosting game. Invite code: %s", inviteCode)
+	serveWebSocket(server, websocket)
+	serveAdmin(ctx, server, g.Match, admin)
+	// Ending the match from the admin console closes the window too.
+	go func() {
+		<-server.Done()
+		g.Stop()
+	}()
+
+	// Wait until a remote player has connected. The host's own connection
+	// holds the left paddle, so the match starts once both are taken.
+	waitForClients(server, 2)
+
+	// Broadcast state updates to all connected clients.
+	go broadcastState(server, g.Match, func() bool { return g.Engine.Running })
+
+	g.RunOverlay(font, client.Stats)
+	client.Disconnect()
+	client.Close()
+	server.Close()
+}
+
+// handleMatchEvents registers the client's handlers for match events, which
+// arrive exactly once over the reliable channel.
+func handleMatchEvents(client *network.Client, g *game.Game) {
+	client.Handle(network.MessageTypeSpectators, spectatorCounter(g))
+	client.Handle(network.MessageTypeMatchStart, func(msg network.Message) {
+		log.Printf("Match started")
+	})
+	client.Handle(network.MessageTypeScore, func(msg network.Message) {
+		if left, right, err := network.DecodeScore(msg.Data); err == nil {
+			log.Printf("Score: %d - %d", left, right)
+		}
+	})
+	client.Handle(network.MessageTypeMatchEnd, func(msg network.Message) {
+		log.Printf("Match ended")
+	})
+}
+
+// connectChat wires the game's chat to client: typed lines go to the
+// server, which relays them to everyone in the match.
+func connectChat(client *network.Client, g *game.Game) {
+	g.Chat.Send = client.Chat
+	client.Handle(network.MessageTypeChat, func(msg network.Message) {
+		if chat, err := network.DecodeChat(msg.Data); err == nil {
+			g.Chat.Add(chat.From, chat.Text)
+		}
+	})
+}
+
+// spectatorCounter returns a client handler for MessageTypeSpectators that
+// keeps the HUD's spectator count up to date.
+func spectatorCounter(g *game.Game) network.ClientHandler {
+	return func(msg network.Message) {
+		if n, err := network.DecodeSpectators(msg.Data); err == nil {
+			g.Spectators.Store(int32(n))
+		}
+	}
+}
+
+// runJoin connects to a host or dedicated server and renders the match,
+// predicting the local paddle and interpolating everything else. When the
+// server assigns the spectator role, every paddle is interpolated. With a
+// rendezvous server, the host is found by invite code instead of address.
+func runJoin(ctx context.Context, eng *engine.Engine, font *ttf.Font, address, joinInviteCode, rendezvous string) {
+	log.Printf("Joining game with invite code: %s", joinInviteCode)
+	client := network.NewClient(address)
+	client.Conditions = conditions
+
+	// Create the game instance. Its state will be updated via server broadcasts.
+	g := game.NewGame(eng)
+
+	// Declare a buffer for received states, filled by the network goroutine.
+	var stateMu sync.Mutex
+	var stateBuffer []shared.State
+	var latest shared.State // newest authoritative state, not yet reconciled
+	var haveLatest bool
+	reconnecting := false
+
+	// Callbacks are set before connecting so that no early message is missed.
+	// Use a state-update callback to reconcile the host's state with local prediction.
+	client.OnStateUpdate = func(s shared.State) {
+		stateMu.Lock()
+		stateBuffer = append(stateBuffer, s)
+		latest, haveLatest = s, true
+		stateMu.Unlock()
+	}
+
+	handleMatchEvents(client, g)
+	connectChat(client, g)
+	// Being kicked ends the game instead of reconnecting.
+	var kicked atomic.Bool
+	client.OnDisconnect = func() {
+		log.Printf("Removed from the match by the server")
+		kicked.Store(true)
+	}
+
+	connect := client.Connect
+	if rendezvous != "" {
+		connect = func(ctx context.Context, code string) error { return client.ConnectVia(ctx, rendezvous, code) }
+	}
+	if err := connect(ctx, joinInviteCode); err != nil {
+		log.Printf("Failed to join the game: %v", err)
+		return
+	}
+
+	log.Printf("Joined as %s", client.Role)
+	g.LocalSlot = client.Slot()
+	local := g.LocalPlayer()
+	var predictor sim.Predictor
+
+	// Create a buffered channel for input updates.
+	inputChan := make(chan network.Message, 20)
+
+	// Start a goroutine dedicated to sending input updates without blocking.
+	go func() {
+		for eng.Running && !kicked.Load() {
+			select {
+			case msg := <-inputChan:
+				if err := client.Send(msg); err != nil {
+					fmt.Println("Error sending input update:", err)
+				}
+			default:
+				sdl.Delay(1)
+			}
+		}
+	}()
+
+	// Run a combined render and input loop.
+	lastRender := time.Now()
+
+	for eng.Running && !kicked.Load() {
+		for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+			switch event.(type) {
+			case *sdl.QuitEvent:
+				eng.Running = false
+			default:
+				g.Chat.HandleEvent(event)
+			}
+		}
+
+		// Update FPS calculation.
+		now := time.Now()
+		dt := now.Sub(lastRender)
+		fps := 1.0 / dt.Seconds()
+		lastRender = now
+
+		// If the server went quiet, reconnect in the background; the session
+		// token gets us our slot back and the server resends a full snapshot.
+		// No state arrives before the match starts, but the server's pings do.
+		stateMu.Lock()
+		if !reconnecting && now.Sub(client.LastHeard()) > reconnectAfter {
+			reconnecting = true
+			go func() {
+				log.Printf("Lost contact with the server, reconnecting")
+				if err := client.Reconnect(ctx); err != nil {
+					log.Printf("Failed to reconnect: %v", err)
+				}
+				stateMu.Lock()
+				reconnecting = false
+				stateMu.Unlock()
+			}()
+		}
+		stateMu.Unlock()
+
+		// The slot may have been handed to someone else while we were
+		// away, in which case the server assigned another role.
+		if slot := client.Slot(); slot != g.LocalSlot {
+			log.Printf("Rejoined in another role (slot %d)", slot)
+			g.LocalSlot = slot
+			local = g.LocalPlayer()
+		}
+
+		// Reconcile the local paddle with the newest authoritative state:
+		// start from the server's position and replay unacknowledged inputs.
+		stateMu.Lock()
+		if haveLatest && local != nil {
+			authY := latest.P2Y
+			if g.LocalSlot == 0 {
+				authY = latest.P1Y
+			}
+			predictor.Reconcile(local, authY, latest.InputSeq)
+			haveLatest = false
+		}
+		stateMu.Unlock()
+
+		// Immediately apply this frame's input to the local paddle
+		// (client-side prediction) and send it to the server.
+		// Spectators have no paddle to control, and while the chat input
+		// box is open the keyboard is typing instead.
+		if local != nil {
+			if direction := g.InputDirection(); direction != 0 {
+				in := network.InputCommand{Direction: int8(direction), DeltaTime: float32(dt.Seconds())}
+				seq := predictor.Apply(local, direction, in.DeltaTime)
+				data, err := network.EncodeInput(in)
+				if err == nil {
+					// Non-blocking send to the input channel.
+					select {
+					case inputChan <- network.Message{Type: network.MessageTypeInputUpdate, Seq: seq, Data: data}:
+					default:
+						// If the channel is full, drop the input update.
+					}
+				}
+			}
+		}
+
+		// Use an adaptive render delay based on the measured RTT.
+		stats := client.Stats()
+		adaptiveDelay := defaultRenderDelay
+		if stats.RTT > 0 {
+			adaptiveDelay = int64(stats.RTT / 2)
+		}
+
+		stateMu.Lock()
+		if len(stateBuffer) >= 2 {
+			renderTime := time.Now().UnixNano() - adaptiveDelay
+
+			// Find the two states surrounding renderTime.
+			var s1, s2 shared.State
+			for i := 0; i < len(stateBuffer)-1; i++ {
+				if stateBuffer[i].Timestamp <= renderTime && renderTime <= stateBuffer[i+1].Timestamp {
+					s1 = stateBuffer[i]
+					s2 = stateBuffer[i+1]
+					break
+				}
+			}
+			// Perform interpolation.
+			duration := s2.Timestamp - s1.Timestamp
+			if duration > 0 {
+				t := float32(renderTime-s1.Timestamp) / float32(duration)
+				interpolatedState := shared.InterpolateState(s1, s2, t)
+				// For client, update only remote objects (ignore the locally controlled paddle).
+				g.ApplyRemoteState(interpolatedState, true)
+			}
+		}
+		stateMu.Unlock()
+
+		// Clear screen and render game.
+		eng.Clear()
+		g.Render()
+
+		// Compose overlay text.
+		infoText := fmt.Sprintf("FPS: %.0f  Ping: %d ms  Loss: %.0f%%  Spectators: %d",
+			fps, stats.RTT.Milliseconds(), 100*stats.Loss(), g.Spectators.Load())
+
+		// Render the overlay text (e.g. at top-left).
+		if err := renderText(eng.Renderer, font, infoText, 10, 10); err != nil {
+			fmt.Println("Error rendering info text:", err)
+		}
+		g.Chat.Render(eng.Renderer, font)
+
+		// Present the updated frame.
+		eng.Present()
+		sdl.Delay(16)
+	}
+	client.Disconnect()
+	client.Close()
+}
+
+func pointInRect(x, y int32, r sdl.Rect) bool {
+	return x >= r.X && x <= (r.X+r.W) && y >= r.Y && y <= (r.Y+r.H)
+}
+
+func drawButton(renderer *sdl.Renderer, rect sdl.Rect, label string) {
+	// Draw a simple colored button.
+	renderer.SetDrawColor(100, 100, 255, 255)
+	renderer.FillRect(&rect)
+	// (Optionally, render the label using a text library such as SDL_ttf.)
+}
+
+func renderText(renderer *sdl.Renderer, font *ttf.Font, text string, x, y int32) error {
+	color := sdl.Color{R: 255, G: 255, B: 255, A: 255}
+	surface, err := font.RenderUTF8Solid(text, color)
+	if err != nil {
+		return err
+	}
+	defer surface.Free()
+	texture, err := renderer.CreateTextureFromSurface(surface)
+	if err != nil {
+		return err
+	}
+	defer texture.Destroy()
+	rect := sdl.Rect{X: x, Y: y, W: surface.W, H: surface.H}
+	return renderer.Copy(texture, nil, &rect)
+}